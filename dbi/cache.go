@@ -0,0 +1,60 @@
+// +build linux
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbi
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/dtype"
+)
+
+// cachedSamples holds samples of the last successful execution of query for database
+type cachedSamples struct {
+	samples []namedSample
+	done    time.Time // time of completion of query execution
+}
+
+// cachedSamples returns samples of the last successful execution of query `queryName` for database `dbName`
+// and true if they are younger than `maxAge`
+func (dbiPlg *DbiPlugin) cachedSamples(dbName, queryName string, maxAge time.Duration) (cachedSamples, bool) {
+	entry, exist := dbiPlg.cache[queryKey(dbName, queryName)]
+	if !exist || time.Since(entry.done) >= maxAge {
+		return cachedSamples{}, false
+	}
+
+	return entry, true
+}
+
+// reuseSamples returns samples of the last successful execution of query `job` which failed,
+// as long as they are younger than cache TTL of the query; expired samples are forgotten
+func (dbiPlg *DbiPlugin) reuseSamples(job *queryJob, query *dtype.Query) []namedSample {
+	if query.CacheTTL == 0 {
+		return nil
+	}
+
+	entry, fresh := dbiPlg.cachedSamples(job.dbName, job.queryName, query.CacheTTL)
+	if !fresh {
+		delete(dbiPlg.cache, queryKey(job.dbName, job.queryName))
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Reusing output of query %s for database %s obtained at %s\n",
+		job.queryName, job.dbName, entry.done.Format(time.RFC3339))
+	return entry.samples
+}
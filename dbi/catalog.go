@@ -0,0 +1,191 @@
+// +build linux
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbi
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/dtype"
+	"github.com/intelsdi-x/snap/control/plugin"
+	"github.com/intelsdi-x/snap/core"
+)
+
+// metricTypes returns catalog of metrics which can be collected from databases `dbs` by executing queries `qrs`,
+// it is built only from their definitions; elements of namespaces created from values of instances' columns
+// are dynamic unless static instances are declared for result
+func metricTypes(dbs map[string]*dtype.Database, qrs map[string]*dtype.Query) []plugin.MetricType {
+	mts := []plugin.MetricType{}
+	exist := map[string]bool{}
+
+	add := func(ns core.Namespace) {
+		// the same namespace can be defined for results of a few queries
+		if !exist[ns.String()] {
+			exist[ns.String()] = true
+			mts = append(mts, plugin.MetricType{Namespace_: ns})
+		}
+	}
+
+	dbNames := []string{}
+	for dbName := range dbs {
+		dbNames = append(dbNames, dbName)
+	}
+	sort.Strings(dbNames)
+
+	for _, dbName := range dbNames {
+		db := dbs[dbName]
+
+		for _, queryName := range db.QrsToExec {
+			query, ok := qrs[queryName]
+			if !ok {
+				continue
+			}
+
+			resNames := []string{}
+			for resName := range query.Results {
+				resNames = append(resNames, resName)
+			}
+			sort.Strings(resNames)
+
+			for _, resName := range resNames {
+				res := query.Results[resName]
+
+				if len(res.Instances) == 0 {
+					add(resultNamespace(dbName, resName, res))
+					continue
+				}
+
+				// static instances are declared in setfile
+				for _, instance := range res.Instances {
+					add(core.NewNamespace(splitNamespace(createNamespace(dbName, resName, res.InstancePrefix, instance))...))
+				}
+			}
+
+			if query.Interval > 0 {
+				add(core.NewNamespace(splitNamespace(createNamespace(dbName, nsSelf, "cache_age", queryName))...))
+			}
+		}
+
+		if suppressesNulls(db, qrs) {
+			add(core.NewNamespace(splitNamespace(createNamespace(dbName, nsSelf, "nulls_suppressed", ""))...))
+		}
+
+		if db.PoolStats {
+			stats := []string{}
+			for name := range poolStats(sql.DBStats{}) {
+				stats = append(stats, name)
+			}
+			sort.Strings(stats)
+
+			for _, name := range stats {
+				add(core.NewNamespace(splitNamespace(createNamespace(dbName, nsSelf, "pool", name))...))
+			}
+		}
+	}
+
+	return mts
+}
+
+// uncoveredMetricTypes returns metric types with static namespaces for these of metrics `data` whose names
+// do not match any namespace of catalog `mts`, i.e. these whose instances consist of a few elements (values
+// of instance's column containing slashes)
+func uncoveredMetricTypes(data samples, mts []plugin.MetricType) []plugin.MetricType {
+	uncovered := []plugin.MetricType{}
+
+	names := []string{}
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		covered := false
+		for _, mt := range mts {
+			if matchNamespace(mt.Namespace().Strings(), splitNamespace(name)) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			uncovered = append(uncovered, plugin.MetricType{Namespace_: core.NewNamespace(splitNamespace(name)...)})
+		}
+	}
+
+	return uncovered
+}
+
+// resultNamespace returns namespace of metrics obtained from result `res`, where each of instances' columns
+// is represented by dynamic element named after the column (preceded by static element of its prefix if defined)
+func resultNamespace(dbName, resName string, res dtype.Result) core.Namespace {
+	ns := core.NewNamespace(splitNamespace(createNamespace(dbName, resName, res.InstancePrefix, ""))...)
+
+	for _, ic := range res.InstanceFrom {
+		if isNotEmpty(ic.Prefix) {
+			ns = ns.AddStaticElement(validateNamespace(ic.Prefix))
+		}
+		ns = ns.AddDynamicElement(validateNamespace(strings.ToLower(ic.Column)), fmt.Sprintf("value of column %s", ic.Column))
+	}
+
+	return ns
+}
+
+// expandNamespace returns copy of namespace `ns` whose elements take values of elements of metric's name `name`
+func expandNamespace(ns core.Namespace, name string) core.Namespace {
+	expanded := make(core.Namespace, len(ns))
+	copy(expanded, ns)
+
+	for i, value := range splitNamespace(name) {
+		expanded[i].Value = value
+	}
+
+	return expanded
+}
+
+// matchingNames returns sorted names of metrics `data` which match namespace `ns` containing wildcards,
+// for namespace without wildcards it is only its name if there is such a metric
+func matchingNames(data samples, ns core.Namespace) []string {
+	pattern := ns.Strings()
+	names := []string{}
+
+	if !isWildcard(pattern) {
+		if _, exist := data[ns.String()]; exist {
+			names = append(names, ns.String())
+		}
+		return names
+	}
+
+	for name := range data {
+		if matchNamespace(pattern, splitNamespace(name)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// isWildcard returns true when any of elements of namespace is wildcard `*`
+func isWildcard(elements []string) bool {
+	for _, e := range elements {
+		if e == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -17,97 +17,257 @@ limitations under the License.
 package dbi
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
-
-	_ "github.com/go-sql-driver/mysql"
-	_ "github.com/lib/pq"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/dtype"
 )
 
-// defaultPort specifies the TCP/IP port on which sql driver is listening
-// for connections from client applications
-var defaultPort = map[string]string{
-	"mysql":    "3306",
-	"postgres": "5432",
+// redacted replaces secrets in error messages
+const redacted = "******"
+
+// minBackoff and maxBackoff limit the delay between subsequent attempts to reconnect the database which is down,
+// the delay is doubled after each failed attempt
+var (
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+// openDB opens a database and verifies connection by calling ping to it, secrets are redacted from returned error.
+// Database becomes healthy on success, otherwise it is down and the next attempt to connect is scheduled.
+func openDB(db *dtype.Database) error {
+	db.State = dtype.StateConnecting
+	// server might have been upgraded in the meantime, its version is determined again when needed
+	db.ServerVersion = nil
+
+	if err := connectDB(db); err != nil {
+		markDown(db)
+		return redactError(err, db)
+	}
+
+	db.State = dtype.StateHealthy
+	db.Failures = 0
+
+	return nil
 }
 
-// getDefaultPort returns default port for specific driver
-func getDefaultPort(driver string) string {
-	return defaultPort[driver]
+// markDown sets state of database to down and schedules the next attempt to connect using exponential backoff
+func markDown(db *dtype.Database) {
+	db.Failures++
+	db.State = dtype.StateDown
+	db.NextRetry = time.Now().Add(backoff(db.Failures))
 }
 
-// openDB opens a database and verifies connection by calling ping to it
-func openDB(db *dtype.Database) error {
-	var dsn string
+// backoff returns the delay before the next attempt to connect after `failures` consecutive failed attempts
+func backoff(failures int) time.Duration {
+	delay := minBackoff
+	for i := 1; i < failures && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	return delay
+}
+
+// ensureConnected returns true if database is connected, the database which is down is reconnected
+// once its backoff has elapsed
+func ensureConnected(dbName string, db *dtype.Database) bool {
+	switch db.State {
+	case dtype.StateHealthy, dtype.StateDegraded:
+		return true
 
-	// if port is not defined, set defaults
-	if isEmpty(db.Port) {
-		db.Port = getDefaultPort(db.Driver)
+	case dtype.StateDown:
+		if time.Now().Before(db.NextRetry) {
+			return false
+		}
+	}
+
+	if err := openDB(db); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot connect to database %s, next attempt at %s: %v\n", dbName, db.NextRetry.Format(time.RFC3339), err)
+		return false
 	}
 
-	switch db.Driver {
-	case "postgres":
-		dsn = fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-			db.Username, db.Password, db.Host, db.Port, db.DBName)
+	fmt.Fprintf(os.Stderr, "Connection to database %s established\n", dbName)
+	return true
+}
+
+// updateState sets state of connected database based on the number of queries which failed out of all executed;
+// when all of them failed and the database does not respond to ping, the connection is closed and database is down
+func updateState(dbName string, db *dtype.Database, failed, executed int) {
+	previous := db.State
 
-	case "mysql":
-		dsn = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s",
-			db.Username, db.Password, db.Host, db.Port, db.DBName)
+	switch {
+	case failed == 0:
+		db.State = dtype.StateHealthy
+
+	case failed < executed:
+		db.State = dtype.StateDegraded
 
 	default:
-		return fmt.Errorf("SQL Driver %s is not supported", db.Driver)
+		if err := db.Executor.Ping(); err != nil {
+			db.Executor.Close()
+			markDown(db)
+			fmt.Fprintf(os.Stderr, "Connection to database %s lost, next attempt at %s: %v\n", dbName, db.NextRetry.Format(time.RFC3339), redactError(err, db))
+			return
+		}
+		db.State = dtype.StateDegraded
+	}
+
+	if db.State != previous {
+		fmt.Fprintf(os.Stderr, "Database %s is %s\n", dbName, db.State)
+	}
+}
+
+// redactError returns error whose message does not contain secrets of database `db` (its password,
+// also in escaped form, and data source name)
+func redactError(err error, db *dtype.Database) error {
+	secrets := []string{
+		db.DSN,
+		db.Password,
+		url.QueryEscape(db.Password),
+		strings.TrimPrefix(url.UserPassword("", db.Password).String(), ":"),
+	}
+
+	msg := err.Error()
+	for _, secret := range secrets {
+		if secret != "" {
+			msg = strings.Replace(msg, secret, redacted, -1)
+		}
+	}
+
+	if msg == err.Error() {
+		return err
 	}
+	return errors.New(msg)
+}
+
+// connectDB opens a database and verifies connection by calling ping to it
+func connectDB(db *dtype.Database) error {
+	var dsn string
+	var switchToDB func(dbName string) string
+
+	if isNotEmpty(db.DSN) {
+		// data source name is given explicitly, pass it to sql driver as it is
+		if !isSQLDriver(db.Driver) {
+			return fmt.Errorf("SQL Driver %s is not available, available drivers: %s", db.Driver, strings.Join(sql.Drivers(), ", "))
+		}
+		dsn = db.DSN
+		switchToDB = useDB
+
+		if driver, err := getDriver(db.Driver); err == nil {
+			switchToDB = driver.SwitchToDB
+		}
+	} else {
+		driver, err := getDriver(db.Driver)
+		if err != nil {
+			return err
+		}
+
+		// if port is not defined, set defaults (port of SQL Server named instance is resolved by SQL Server Browser,
+		// port is not needed either when connecting through Unix-domain socket)
+		if isEmpty(db.Port) && isEmpty(db.Instance) && isEmpty(db.Socket) {
+			db.Port = driver.DefaultPort
+		}
+
+		dsn, err = driver.DSN(db)
+		if err != nil {
+			return err
+		}
+		switchToDB = driver.SwitchToDB
+	}
+
 	err := db.Executor.Open(db.Driver, dsn)
 	if err != nil {
 		return err
 	}
+	db.Executor.SetPool(db.MaxOpenConns, db.MaxIdleConns, db.ConnMaxLifetime)
 
 	// ping db to verify a connection
 	if err = db.Executor.Ping(); err != nil {
+		db.Executor.Close()
 		return err
 	}
 
 	if db.SelectDB != "" {
+		if switchToDB == nil {
+			db.Executor.Close()
+			return fmt.Errorf("SQL Driver %s does not support switching to database %s", db.Driver, db.SelectDB)
+		}
+
 		// switch the connection when SelectDB is defined in cfg
-		err = db.Executor.SwitchToDB(db.SelectDB)
+		err = db.Executor.SwitchToDB(switchToDB(db.SelectDB))
 		if err != nil {
+			db.Executor.Close()
 			return err
 		}
 	}
 
-	db.Active = true
-
 	return nil
 }
 
-// openDBs opens databases and verifies connections by calling ping to them
+// OpenError is returned when some of databases cannot be opened, it holds errors of each of them
+type OpenError struct {
+	Errors map[string]error // errors of opening databases, keys are names of databases
+	Opened int              // number of databases opened successfully
+}
+
+// Error returns errors of all databases which cannot be opened
+func (e *OpenError) Error() string {
+	names := []string{}
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := []string{}
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", name, e.Errors[name]))
+	}
+
+	return fmt.Sprintf("Cannot open %d of %d database(s): %s", len(e.Errors), len(e.Errors)+e.Opened, strings.Join(msgs, "; "))
+}
+
+// openDBs opens databases independently and verifies connections by calling ping to them,
+// returns OpenError when some of them cannot be opened
 func openDBs(dbs map[string]*dtype.Database) error {
-	once := false
-	for i := range dbs {
-		err := openDB(dbs[i])
-		if err != nil {
-			return err
+	openErr := &OpenError{Errors: map[string]error{}}
+
+	for name, db := range dbs {
+		if err := openDB(db); err != nil {
+			openErr.Errors[name] = err
+			continue
 		}
-		once = true
+		openErr.Opened++
+	}
+
+	if len(openErr.Errors) > 0 {
+		return openErr
 	}
 
-	if !once {
+	if openErr.Opened == 0 {
 		return errors.New("Cannot open any of defined database")
 	}
 
 	return nil
 }
 
-// closeDB closes a database
+// closeDB closes a database which is connected
 func closeDB(db *dtype.Database) error {
-	if db.Active {
+	if db.State == dtype.StateHealthy || db.State == dtype.StateDegraded {
 		err := db.Executor.Close()
 		if err != nil {
 			return err
 		}
-		db.Active = false
+		db.State = dtype.StateConnecting
 	}
 	return nil
 }
@@ -0,0 +1,213 @@
+// +build linux
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbi
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/dtype"
+)
+
+// clockDuration matches durations in format hh:mm:ss[.fraction], e.g. values of MySQL TIME columns
+var clockDuration = regexp.MustCompile(`^(-?)(\d+):(\d{2}):(\d{2}(?:\.\d+)?)$`)
+
+// convertValue converts value of column `arg` to type `valueType` (see parser.valueTypes), error is returned
+// when it is not possible without losing information; durations are expressed in seconds and timestamps
+// in Unix time in seconds
+func convertValue(arg interface{}, valueType string) (interface{}, error) {
+	if arg == nil {
+		return nil, fmt.Errorf("value is NULL")
+	}
+
+	if b, ok := arg.([]byte); ok {
+		arg = string(b)
+	}
+
+	switch valueType {
+	case "int":
+		return toInt(arg)
+
+	case "float":
+		return toFloat(arg)
+
+	case "bool":
+		return toBool(arg)
+
+	case "string":
+		return fmt.Sprintf("%v", fixDataType(arg)), nil
+
+	case "duration":
+		return toDuration(arg)
+
+	case "timestamp":
+		t, err := parseTimestamp(arg)
+		if err != nil {
+			return nil, err
+		}
+		return t.Unix(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported value type `%s`", valueType)
+}
+
+// toInt converts `arg` to int64, floats are accepted only if they are integral
+func toInt(arg interface{}) (interface{}, error) {
+	switch v := arg.(type) {
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return nil, fmt.Errorf("value %d is out of range of int", v)
+		}
+		return int64(v), nil
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > math.MaxInt64 {
+			return nil, fmt.Errorf("value %v is not an integer", v)
+		}
+		return int64(v), nil
+	case float32:
+		return toInt(float64(v))
+	case string:
+		i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("value `%s` is not an integer", v)
+		}
+		return i, nil
+	}
+
+	return nil, fmt.Errorf("value of type %T cannot be converted to int", arg)
+}
+
+// toFloat converts `arg` to float64
+func toFloat(arg interface{}) (interface{}, error) {
+	switch v := arg.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("value `%s` is not a number", v)
+		}
+		return f, nil
+	}
+
+	return nil, fmt.Errorf("value of type %T cannot be converted to float", arg)
+}
+
+// toBool converts `arg` to bool, numbers are accepted only if they are equal to 0 or 1
+func toBool(arg interface{}) (interface{}, error) {
+	switch v := arg.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("value `%s` is not a boolean", v)
+		}
+		return b, nil
+	}
+
+	f, err := toFloat(arg)
+	if err != nil {
+		return nil, fmt.Errorf("value of type %T cannot be converted to bool", arg)
+	}
+	switch f.(float64) {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	}
+
+	return nil, fmt.Errorf("value %v is not a boolean", arg)
+}
+
+// toDuration converts `arg` to number of seconds, accepted are numbers (of seconds), time.Duration and strings
+// in format of time.ParseDuration (e.g. "1m30s") or hh:mm:ss[.fraction]
+func toDuration(arg interface{}) (interface{}, error) {
+	switch v := arg.(type) {
+	case time.Duration:
+		return v.Seconds(), nil
+	case string:
+		str := strings.TrimSpace(v)
+
+		if f, err := strconv.ParseFloat(str, 64); err == nil {
+			return f, nil
+		}
+		if d, err := time.ParseDuration(str); err == nil {
+			return d.Seconds(), nil
+		}
+		if m := clockDuration.FindStringSubmatch(str); m != nil {
+			hours, _ := strconv.ParseFloat(m[2], 64)
+			minutes, _ := strconv.ParseFloat(m[3], 64)
+			seconds, _ := strconv.ParseFloat(m[4], 64)
+			total := hours*3600 + minutes*60 + seconds
+			if m[1] == "-" {
+				total = -total
+			}
+			return total, nil
+		}
+		return nil, fmt.Errorf("value `%s` is not a duration", v)
+	}
+
+	f, err := toFloat(arg)
+	if err != nil {
+		return nil, fmt.Errorf("value of type %T cannot be converted to duration", arg)
+	}
+	return f, nil
+}
+
+// mapValue returns number to which value `arg` is mapped by the first matching mapping of `valueMap`,
+// or `unmapped` if none of them matches (false is returned when it is not defined)
+func mapValue(arg interface{}, valueMap []dtype.ValueMapping, unmapped *float64) (float64, bool) {
+	str := fmt.Sprintf("%v", fixDataType(arg))
+
+	for _, vm := range valueMap {
+		if vm.Regex != nil {
+			if vm.Regex.MatchString(str) {
+				return vm.To, true
+			}
+			continue
+		}
+		if vm.From == str {
+			return vm.To, true
+		}
+	}
+
+	if unmapped != nil {
+		return *unmapped, true
+	}
+	return 0, false
+}
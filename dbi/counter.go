@@ -0,0 +1,112 @@
+// +build linux
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbi
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// previousSample holds value of metric obtained in the previous collection, it is used to compute delta or rate
+type previousSample struct {
+	value     float64
+	timestamp time.Time
+	job       string // database and query which returned the sample
+}
+
+// seriesKey returns key which identifies series of samples of metric `name` with tags `tags`
+func seriesKey(name string, tags map[string]string) string {
+	pairs := []string{}
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+
+	return name + "?" + strings.Join(pairs, "&")
+}
+
+// derive replaces values of samples `named` returned by query `job` with their deltas or rates (per second)
+// since samples of the previous collection, according to outputs of their results; samples which have
+// no previous value yet or whose counter has been reset are omitted. Previous samples of series which are
+// not returned by the query anymore are forgotten. Samples are recorded as previous ones only if `advance`
+// is true, samples which are not published (e.g. obtained to build the catalog) leave previous ones intact.
+func (dbiPlg *DbiPlugin) derive(job *queryJob, named []namedSample, advance bool) []namedSample {
+	jobID := queryKey(job.dbName, job.queryName)
+	derived := []namedSample{}
+	seen := map[string]bool{}
+
+	for _, ns := range named {
+		if ns.output != "delta" && ns.output != "rate" {
+			derived = append(derived, ns)
+			continue
+		}
+
+		value, err := toFloat(ns.value)
+		if err != nil {
+			// log value which is not a number and take the next one
+			fmt.Fprintf(os.Stderr, "Cannot compute %s of metric %s: %v\n", ns.output, ns.name, err)
+			continue
+		}
+
+		timestamp := ns.timestamp
+		if timestamp.IsZero() {
+			timestamp = job.done
+		}
+
+		key := seriesKey(ns.name, ns.tags)
+		seen[key] = true
+		previous, exist := dbiPlg.previous[key]
+		if advance {
+			dbiPlg.previous[key] = previousSample{value: value.(float64), timestamp: timestamp, job: jobID}
+		}
+
+		if !exist {
+			// the first sample of series
+			continue
+		}
+
+		delta := value.(float64) - previous.value
+		if ns.kind == "counter" && delta < 0 {
+			// counter has been reset, current value is a new base
+			fmt.Fprintf(os.Stderr, "Counter %s has been reset\n", ns.name)
+			continue
+		}
+
+		if ns.output == "delta" {
+			ns.value = delta
+		} else {
+			elapsed := timestamp.Sub(previous.timestamp).Seconds()
+			if elapsed <= 0 {
+				continue
+			}
+			ns.value = delta / elapsed
+		}
+
+		derived = append(derived, ns)
+	}
+
+	for key, previous := range dbiPlg.previous {
+		if advance && previous.job == jobID && !seen[key] {
+			delete(dbiPlg.previous, key)
+		}
+	}
+
+	return derived
+}
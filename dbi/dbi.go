@@ -19,15 +19,15 @@ package dbi
 import (
 	"fmt"
 	"os"
-	"strings"
+	"sort"
 	"time"
 
 	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/dtype"
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/executor"
 	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/parser"
 	"github.com/intelsdi-x/snap-plugin-utilities/config"
 	"github.com/intelsdi-x/snap/control/plugin"
 	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
-	"github.com/intelsdi-x/snap/core"
 )
 
 const (
@@ -37,12 +37,28 @@ const (
 	Version = 4
 	// Type of plugin
 	Type = plugin.CollectorPluginType
+
+	// defaultMinDatabases is the default minimum number of databases which have to be opened to collect metrics
+	defaultMinDatabases = 1
+	// defaultConcurrency is the default maximum number of queries executed at the same time
+	defaultConcurrency = 4
 )
 
 // DbiPlugin holds information about the configuration database and defined queries
 type DbiPlugin struct {
-	databases   map[string]*dtype.Database
-	queries     map[string]*dtype.Query
+	databases    map[string]*dtype.Database
+	queries      map[string]*dtype.Query
+	index        queryIndex
+	previous     map[string]previousSample // samples of the previous collection, keyed by series
+	minDatabases int
+	concurrency  int
+	// schemaCatalog means that catalog of metrics is built only from setfile, without executing queries
+	schemaCatalog bool
+	// marks holds high-water marks of incremental queries keyed by database and query, persisted to stateFile (if set)
+	marks     map[string]interface{}
+	stateFile string
+	// cache holds samples of queries executed at intervals keyed by database and query
+	cache       map[string]cachedSamples
 	initialized bool
 }
 
@@ -51,7 +67,7 @@ func (dbiPlg *DbiPlugin) CollectMetrics(mts []plugin.MetricType) ([]plugin.Metri
 
 	var err error
 	metrics := []plugin.MetricType{}
-	data := map[string]interface{}{}
+	data := samples{}
 
 	// initialization - done once
 	if dbiPlg.initialized == false {
@@ -61,30 +77,39 @@ func (dbiPlg *DbiPlugin) CollectMetrics(mts []plugin.MetricType) ([]plugin.Metri
 			// Cannot obtained sql settings
 			return nil, err
 		}
-		err = openDBs(dbiPlg.databases)
+		err = dbiPlg.checkAvailability(openDBs(dbiPlg.databases))
 		if err != nil {
+			// not enough databases available, close these opened
+			closeDBs(dbiPlg.databases)
 			return nil, err
 		}
 		dbiPlg.initialized = true
 	} // end of initialization
-	// execute dbs queries and get output
-	data, err = dbiPlg.executeQueries()
+	// execute dbs queries and get output, only queries which produce requested metrics are executed
+	nss := make([]string, len(mts))
+	for i, m := range mts {
+		nss[i] = m.Namespace().String()
+	}
+
+	data, err = dbiPlg.executeQueries(dbiPlg.index.selectQueries(nss))
 	if err != nil {
 		return nil, err
 	}
 
 	for _, m := range mts {
-		if value, ok := data[m.Namespace().String()]; ok {
-			metric := plugin.MetricType{
-				Namespace_: m.Namespace(),
-				Data_:      value,
-				Timestamp_: time.Now(),
-				Tags_:      m.Tags(),
-				Version_:   m.Version(),
+		// requested namespace with wildcards (dynamic elements) is expanded to all matching metrics
+		for _, name := range matchingNames(data, m.Namespace()) {
+			for _, s := range data[name] {
+				metric := plugin.MetricType{
+					Namespace_: expandNamespace(m.Namespace(), name),
+					Data_:      s.value,
+					Timestamp_: timestampOf(s),
+					Tags_:      mergeTags(m.Tags(), s.tags),
+					Version_:   m.Version(),
+				}
+				metrics = append(metrics, metric)
 			}
-			metrics = append(metrics, metric)
 		}
-
 	}
 
 	return metrics, nil
@@ -98,30 +123,31 @@ func (dbiPlg *DbiPlugin) GetConfigPolicy() (*cpolicy.ConfigPolicy, error) {
 
 // GetMetricTypes returns metrics types exposed by snap-plugin-collector-dbi
 func (dbiPlg *DbiPlugin) GetMetricTypes(cfg plugin.ConfigType) ([]plugin.MetricType, error) {
-	metrics := map[string]interface{}{}
-	mts := []plugin.MetricType{}
-
 	err := dbiPlg.setConfig(cfg)
 	if err != nil {
 		// cannot obtained sql settings from Global Config
 		return nil, err
 	}
 
-	metrics, err = dbiPlg.getMetrics()
-	if err != nil {
-		return nil, err
+	// catalog contains dynamic elements for instances, so metrics of rows returned later are collectable as well
+	mts := metricTypes(dbiPlg.databases, dbiPlg.queries)
+
+	if dbiPlg.schemaCatalog {
+		// databases are not touched
+		return mts, nil
 	}
 
-	for name := range metrics {
-		mts = append(mts, plugin.MetricType{Namespace_: core.NewNamespace(splitNamespace(name)...)})
+	metrics, err := dbiPlg.getMetrics()
+	if err != nil {
+		return nil, err
 	}
 
-	return mts, nil
+	return append(mts, uncoveredMetricTypes(metrics, mts)...), nil
 }
 
 // New returns snap-plugin-collector-dbi instance
 func New() *DbiPlugin {
-	dbiPlg := &DbiPlugin{databases: map[string]*dtype.Database{}, queries: map[string]*dtype.Query{}, initialized: false}
+	dbiPlg := &DbiPlugin{databases: map[string]*dtype.Database{}, queries: map[string]*dtype.Query{}, previous: map[string]previousSample{}, cache: map[string]cachedSamples{}, minDatabases: defaultMinDatabases, concurrency: defaultConcurrency, initialized: false}
 
 	return dbiPlg
 }
@@ -141,21 +167,94 @@ func (dbiPlg *DbiPlugin) setConfig(cfg interface{}) error {
 		return err
 	}
 
+	if err = validateDrivers(dbiPlg.databases); err != nil {
+		return err
+	}
+
+	// index of namespaces' prefixes is used to select queries producing requested metrics
+	dbiPlg.index = newQueryIndex(dbiPlg.databases, dbiPlg.queries)
+
+	// minimum number of databases which have to be opened is optional
+	dbiPlg.minDatabases = defaultMinDatabases
+	if minDatabases, err := config.GetConfigItem(cfg, "min_databases"); err == nil {
+		value, ok := minDatabases.(int)
+		if !ok || value < 1 {
+			return fmt.Errorf("Config item `min_databases` has to be a positive integer, got %v", minDatabases)
+		}
+		dbiPlg.minDatabases = value
+	}
+
+	// maximum number of queries executed at the same time is optional
+	dbiPlg.concurrency = defaultConcurrency
+	if concurrency, err := config.GetConfigItem(cfg, "concurrency"); err == nil {
+		value, ok := concurrency.(int)
+		if !ok || value < 1 {
+			return fmt.Errorf("Config item `concurrency` has to be a positive integer, got %v", concurrency)
+		}
+		dbiPlg.concurrency = value
+	}
+
+	// source of metrics catalog is optional, by default queries are executed to obtain it
+	dbiPlg.schemaCatalog = false
+	if catalog, err := config.GetConfigItem(cfg, "catalog"); err == nil {
+		switch catalog {
+		case "query":
+		case "schema":
+			dbiPlg.schemaCatalog = true
+		default:
+			return fmt.Errorf("Config item `catalog` has to be `query` or `schema`, got %v", catalog)
+		}
+	}
+
+	// state file which keeps high-water marks of incremental queries between restarts is optional
+	dbiPlg.stateFile = ""
+	if stateFile, err := config.GetConfigItem(cfg, "state_file"); err == nil {
+		value, ok := stateFile.(string)
+		if !ok {
+			return fmt.Errorf("Config item `state_file` has to be a string, got %v", stateFile)
+		}
+		dbiPlg.stateFile = value
+	}
+
+	// high-water marks are read once, later they are kept in memory
+	if dbiPlg.marks == nil {
+		marks, err := loadMarks(dbiPlg.stateFile)
+		if err != nil {
+			return err
+		}
+		dbiPlg.marks = marks
+	}
+
+	return nil
+}
+
+// checkAvailability returns nil when all of databases are opened or at least `minDatabases` of them in case of OpenError,
+// which is logged then (databases which cannot be opened are reconnected later)
+func (dbiPlg *DbiPlugin) checkAvailability(err error) error {
+	openErr, ok := err.(*OpenError)
+	if !ok || openErr.Opened < dbiPlg.minDatabases {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%v, collecting metrics from %d available database(s)\n", openErr, openErr.Opened)
 	return nil
 }
 
 // getMetrics returns map with dbi metrics values, where keys are metrics names
-func (dbiPlg *DbiPlugin) getMetrics() (map[string]interface{}, error) {
-	metrics := map[string]interface{}{}
+func (dbiPlg *DbiPlugin) getMetrics() (samples, error) {
+	metrics := samples{}
 
-	err := openDBs(dbiPlg.databases)
+	err := dbiPlg.checkAvailability(openDBs(dbiPlg.databases))
 
 	if err != nil {
+		// not enough databases available, close these opened
+		closeDBs(dbiPlg.databases)
 		return nil, err
 	}
 
-	// execute dbs queries and get statement outputs
-	metrics, err = dbiPlg.executeQueries()
+	// execute dbs queries and get statement outputs, high-water marks of incremental queries are not advanced
+	// as metrics obtained to build the catalog are not published
+	metrics, err = dbiPlg.runQueries(nil, false)
 	if err != nil {
 		return nil, err
 	}
@@ -171,68 +270,199 @@ func (dbiPlg *DbiPlugin) getMetrics() (map[string]interface{}, error) {
 	return metrics, nil
 }
 
-// executeQueries executes all defined queries of each database and returns results as map to its values,
-// where keys are equal to columns' names
-func (dbiPlg *DbiPlugin) executeQueries() (map[string]interface{}, error) {
-	data := map[string]interface{}{}
+// executeQueries executes queries `selected` for each database (all defined queries when nil) and returns
+// samples of metrics obtained from their results, where keys are metrics names; queries are executed concurrently, but their outputs are merged
+// in deterministic order (sorted by names of databases, then in order of queries defined for database)
+func (dbiPlg *DbiPlugin) executeQueries(selected map[string]map[string]bool) (samples, error) {
+	return dbiPlg.runQueries(selected, true)
+}
+
+// runQueries executes queries like executeQueries, high-water marks of incremental queries are advanced
+// (and persisted to state file) only if `advance` is true and all outputs are obtained successfully;
+// previous samples of counters and numbers of suppressed NULL values are updated only if `advance` is true
+func (dbiPlg *DbiPlugin) runQueries(selected map[string]map[string]bool, advance bool) (samples, error) {
+	data := samples{}
+	jobs := []*queryJob{}
+	connected := []string{}
+	executed := map[string]int{}
+
+	dbNames := []string{}
+	for dbName := range dbiPlg.databases {
+		dbNames = append(dbNames, dbName)
+	}
+	sort.Strings(dbNames)
+
+	failed := map[string]int{}
 
-	//execute queries for each defined databases
-	for dbName, db := range dbiPlg.databases {
-		if !db.Active {
-			//skip if db is not active (none established connection)
-			fmt.Fprintf(os.Stderr, "Cannot execute queries for database %s, is inactive (connection was not established properly)\n", dbName)
+	//collect queries to be executed for each defined databases
+	for _, dbName := range dbNames {
+		db := dbiPlg.databases[dbName]
+
+		if !ensureConnected(dbName, db) {
+			//skip if db is not connected, reconnect is scheduled
+			fmt.Fprintf(os.Stderr, "Cannot execute queries for database %s, is %s (next attempt to connect at %s)\n",
+				dbName, db.State, db.NextRetry.Format(time.RFC3339))
 			continue
 		}
+		connected = append(connected, dbName)
 
 		// retrive name from queries to be executed for this db
 		for _, queryName := range db.QrsToExec {
-			statement := dbiPlg.queries[queryName].Statement
+			if selected != nil && !selected[dbName][queryName] {
+				// query does not produce any of requested metrics
+				continue
+			}
+			query := dbiPlg.queries[queryName]
+
+			if query.Interval > 0 {
+				if _, fresh := dbiPlg.cachedSamples(dbName, queryName, query.Interval); fresh {
+					// query is not executed until its interval elapses
+					jobs = append(jobs, &queryJob{dbName: dbName, queryName: queryName, cached: true})
+					continue
+				}
+			}
+			executed[dbName]++
 
-			out, err := db.Executor.Query(queryName, statement)
+			// arguments defined for database override default arguments of query
+			args, exist := db.QueryArgs[queryName]
+			if !exist {
+				args = query.Args
+			}
+
+			statement, err := selectStatement(db, query)
+			if err == nil && query.Incremental != nil {
+				statement, args, err = bindMark(statement, args, query.Incremental, dbiPlg.mark(dbName, queryName, query.Incremental), db.Driver)
+			}
 			if err != nil {
-				// log failing query and take the next one
-				fmt.Fprintf(os.Stderr, "Cannot execute query %s for database %s", queryName, dbName)
+				// log query which cannot be executed for this database and take the next one
+				fmt.Fprintf(os.Stderr, "Cannot execute query %s for database %s: %v\n", queryName, dbName, err)
+				failed[dbName]++
 				continue
 			}
+			jobs = append(jobs, &queryJob{dbName: dbName, queryName: queryName, statement: statement, args: args})
+		}
+	}
 
-			for resName, res := range dbiPlg.queries[queryName].Results {
-				instanceOk := false
-				// to avoid inconsistency of columns names caused by capital letters (especially for postgresql driver)
-				instanceFrom := strings.ToLower(res.InstanceFrom)
-				valueFrom := strings.ToLower(res.ValueFrom)
+	dbiPlg.runJobs(jobs)
+
+	// samples whose deltas or rates cannot be computed yet (e.g. in the first collection)
+	withheld := 0
+	// high-water marks of incremental queries which succeeded
+	marks := map[string]interface{}{}
+	for _, job := range jobs {
+		query := dbiPlg.queries[job.queryName]
+		key := queryKey(job.dbName, job.queryName)
+
+		var derived []namedSample
+		switch {
+		case job.cached:
+			// samples of the previous execution are reused until interval of query elapses
+			derived = dbiPlg.cache[key].samples
+
+		case job.err == executor.ErrTimeout:
+			// log timed out query and take the next one
+			fmt.Fprintf(os.Stderr, "Query %s for database %s timed out after %s\n", job.queryName, job.dbName, job.timeout)
+			failed[job.dbName]++
+			derived = dbiPlg.reuseSamples(job, query)
+
+		case job.err != nil:
+			// log failing query and take the next one
+			fmt.Fprintf(os.Stderr, "Cannot execute query %s for database %s: %v\n", job.queryName, job.dbName,
+				redactError(job.err, dbiPlg.databases[job.dbName]))
+			failed[job.dbName]++
+			derived = dbiPlg.reuseSamples(job, query)
+
+		default:
+			named, mark, err := dbiPlg.jobOutput(job, query, advance)
+			if err != nil {
+				// log query which failed because of its output and take the next one
+				fmt.Fprintf(os.Stderr, "Query %s for database %s failed: %v\n", job.queryName, job.dbName, err)
+				failed[job.dbName]++
+				derived = dbiPlg.reuseSamples(job, query)
+				break
+			}
 
-				if !isEmpty(instanceFrom) {
-					if len(out[instanceFrom]) == len(out[valueFrom]) {
-						instanceOk = true
-					}
-				}
+			if query.Incremental != nil {
+				marks[key] = mark
+			}
+
+			derived = dbiPlg.derive(job, named, advance)
+			withheld += len(named) - len(derived)
 
-				for index, value := range out[valueFrom] {
-					instance := ""
+			if advance && query.Interval > 0 {
+				dbiPlg.cache[key] = cachedSamples{samples: derived, done: job.done}
+			}
+		}
+
+		for _, ns := range derived {
+			if err := data.add(ns.name, ns.sample); err != nil {
+				return nil, err
+			}
+		}
+	} // end of range jobs
 
-					if instanceOk {
-						instance = fmt.Sprintf("%v", fixDataType(out[instanceFrom][index]))
-					}
+	for _, dbName := range connected {
+		db := dbiPlg.databases[dbName]
 
-					key := createNamespace(dbName, resName, res.InstancePrefix, instance)
+		updateState(dbName, db, failed[dbName], executed[dbName])
 
-					if _, exist := data[key]; exist {
-						return nil, fmt.Errorf("Namespace `%s` has to be unique, but is not", key)
-					}
+		if db.PoolStats {
+			for name, value := range poolStats(db.Executor.Stats()) {
+				key := createNamespace(dbName, nsSelf, "pool", name)
 
-					data[key] = fixDataType(value)
+				if err := data.add(key, sample{value: value}); err != nil {
+					return nil, err
 				}
 			}
-		} // end of range db_queries_to_execute
-	} // end of range databases
+		}
+
+		// age of samples of queries executed at intervals
+		for _, queryName := range db.QrsToExec {
+			if entry, exist := dbiPlg.cache[queryKey(dbName, queryName)]; exist {
+				key := createNamespace(dbName, nsSelf, "cache_age", queryName)
+
+				if err := data.add(key, sample{value: time.Since(entry.done).Seconds()}); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if suppressesNulls(db, dbiPlg.queries) {
+			key := createNamespace(dbName, nsSelf, "nulls_suppressed", "")
+
+			if err := data.add(key, sample{value: db.NullsSuppressed}); err != nil {
+				return nil, err
+			}
+		}
+	} // end of range connected databases
 
-	if len(data) == 0 {
+	if len(data) == 0 && withheld == 0 {
 		return nil, fmt.Errorf("No data obtained from defined queries")
 	}
 
+	if advance && len(marks) > 0 {
+		dbiPlg.advanceMarks(marks)
+	}
+
 	return data, nil
 }
 
+// advanceMarks sets high-water marks of incremental queries to `marks` and persists all of them to state file (if set),
+// failure to write the file is logged and marks are kept in memory
+func (dbiPlg *DbiPlugin) advanceMarks(marks map[string]interface{}) {
+	for key, mark := range marks {
+		dbiPlg.marks[key] = mark
+	}
+
+	if isEmpty(dbiPlg.stateFile) {
+		return
+	}
+
+	if err := saveMarks(dbiPlg.stateFile, dbiPlg.marks); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write state file %s: %v\n", dbiPlg.stateFile, err)
+	}
+}
+
 // fixDataType converts `arg` to a string if its type is an array of bytes or time.Time, in other case there is no change
 func fixDataType(arg interface{}) interface{} {
 	var result interface{}
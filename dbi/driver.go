@@ -0,0 +1,333 @@
+// +build linux
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbi
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/dtype"
+)
+
+// Driver describes how to connect to a database with the use of sql driver registered in database/sql
+type Driver struct {
+	// DefaultPort is the TCP/IP port on which database server is listening for connections
+	// from client applications, used when port is not defined (optional)
+	DefaultPort string
+
+	// DSN returns data source name of the database
+	DSN func(db *dtype.Database) (string, error)
+
+	// SwitchToDB returns statement which changes the database context to the specified database,
+	// nil if switching is not supported by the database (optional)
+	SwitchToDB func(dbName string) string
+
+	// Version is statement which returns version of database server, used to choose statements of queries
+	// specific for versions of server (optional)
+	Version string
+
+	// Placeholder returns placeholder of n-th parameter of statement (numbered from 1), used to bind high-water marks
+	// of incremental queries; drivers whose placeholders are not numbered return the same one for each n (optional)
+	Placeholder func(n int) string
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = map[string]*Driver{}
+)
+
+// RegisterDriver makes a driver available under the provided name (which has to be equal to the name of sql driver
+// registered in database/sql). If RegisterDriver is called twice with the same name or if driver is nil, it panics.
+func RegisterDriver(name string, driver *Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if driver == nil || driver.DSN == nil {
+		panic("dbi: RegisterDriver driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("dbi: RegisterDriver called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// getDriver returns driver registered under the provided name
+func getDriver(name string) (*Driver, error) {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	driver, ok := drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("SQL Driver %s is not supported, registered drivers: %s", name, strings.Join(registeredDrivers(), ", "))
+	}
+	return driver, nil
+}
+
+// registeredDrivers returns a sorted list of the names of the registered drivers
+func registeredDrivers() []string {
+	names := []string{}
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateDrivers checks whether drivers of all databases are available, returns error for the first one which is not
+func validateDrivers(dbs map[string]*dtype.Database) error {
+	for name, db := range dbs {
+		if isNotEmpty(db.DSN) {
+			if !isSQLDriver(db.Driver) {
+				return fmt.Errorf("Database %s has SQL Driver %s which is not available, available drivers: %s",
+					name, db.Driver, strings.Join(sql.Drivers(), ", "))
+			}
+			continue
+		}
+
+		if _, err := getDriver(db.Driver); err != nil {
+			return fmt.Errorf("Database %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// isSQLDriver returns true when sql driver `name` is registered in database/sql
+func isSQLDriver(name string) bool {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return true
+		}
+	}
+	return false
+}
+
+// useDB returns `USE` statement which changes the database context, valid for MySQL and SQL Server
+func useDB(dbName string) string {
+	return "USE " + dbName
+}
+
+// questionMark returns `?` placeholder of parameter, valid for MySQL and SQLite
+func questionMark(n int) string {
+	return "?"
+}
+
+// dollarPlaceholder returns numbered placeholder `$n` of n-th parameter, valid for PostgreSQL and for SQL Server
+// driver `mssql` (which rewrites statements, so its parameters are given as `?`, `$n` or `:name`)
+func dollarPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// mssqlPlaceholder returns numbered placeholder `@pn` of n-th parameter of SQL Server statement,
+// valid for driver `sqlserver` which passes statements as they are
+func mssqlPlaceholder(n int) string {
+	return fmt.Sprintf("@p%d", n)
+}
+
+// mssqlEncrypt specifies supported encryption modes of SQL Server connection
+var mssqlEncrypt = map[string]bool{
+	"disable": true,
+	"false":   true,
+	"true":    true,
+}
+
+func init() {
+	mssqlVersion := "SELECT CAST(SERVERPROPERTY('ProductVersion') AS VARCHAR(128))"
+
+	RegisterDriver("mysql", &Driver{DefaultPort: "3306", DSN: mysqlDSN, SwitchToDB: useDB, Placeholder: questionMark,
+		Version: "SELECT VERSION()"})
+	// PostgreSQL cannot switch the database of established connection
+	RegisterDriver("postgres", &Driver{DefaultPort: "5432", DSN: postgresDSN, Placeholder: dollarPlaceholder,
+		Version: "SHOW server_version"})
+	RegisterDriver("sqlite3", &Driver{DSN: sqliteDSN, Placeholder: questionMark, Version: "SELECT sqlite_version()"})
+	RegisterDriver("mssql", &Driver{DefaultPort: "1433", DSN: mssqlDSN, SwitchToDB: useDB, Placeholder: dollarPlaceholder,
+		Version: mssqlVersion})
+	RegisterDriver("sqlserver", &Driver{DefaultPort: "1433", DSN: mssqlDSN, SwitchToDB: useDB, Placeholder: mssqlPlaceholder,
+		Version: mssqlVersion})
+}
+
+// postgresDSN returns URL of PostgreSQL database (or connection string in form of `key=value` pairs in case
+// of connection through Unix-domain socket), SSL is disabled unless SSL mode is defined
+func postgresDSN(db *dtype.Database) (string, error) {
+	if isNotEmpty(db.SSLServerName) {
+		return "", fmt.Errorf("SSL server name is not supported by SQL Driver %s", db.Driver)
+	}
+
+	params := url.Values{}
+	params.Set("sslmode", "disable")
+
+	if isNotEmpty(db.SSLMode) {
+		params.Set("sslmode", db.SSLMode)
+	}
+
+	if isNotEmpty(db.SSLCA) {
+		params.Set("sslrootcert", db.SSLCA)
+	}
+
+	if isNotEmpty(db.SSLCert) {
+		params.Set("sslcert", db.SSLCert)
+		params.Set("sslkey", db.SSLKey)
+	}
+
+	if isNotEmpty(db.Socket) {
+		// directory containing the socket is given as host, port is a part of socket file name
+		params.Set("host", db.Socket)
+
+		options := map[string]string{"port": db.Port, "user": db.Username, "password": db.Password, "dbname": db.DBName}
+		for key, value := range options {
+			if isNotEmpty(value) {
+				params.Set(key, value)
+			}
+		}
+
+		return postgresConnString(params), nil
+	}
+
+	host := db.Host
+	if isNotEmpty(db.Port) {
+		host += ":" + db.Port
+	}
+
+	// user info is escaped, so passwords may contain characters reserved in URL
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(db.Username, db.Password),
+		Host:     host,
+		Path:     "/" + db.DBName,
+		RawQuery: params.Encode(),
+	}
+
+	return dsn.String(), nil
+}
+
+// postgresConnString returns PostgreSQL connection string in form of `key=value` pairs separated by spaces,
+// sorted by key, where values are quoted if needed
+func postgresConnString(params url.Values) string {
+	keys := []string{}
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := []string{}
+	for _, key := range keys {
+		value := params.Get(key)
+		if value == "" || strings.ContainsAny(value, ` '\`) {
+			value = "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+		}
+		pairs = append(pairs, key+"="+value)
+	}
+
+	return strings.Join(pairs, " ")
+}
+
+// mysqlDSN returns data source name of MySQL database, with custom TLS configuration if SSL mode is defined
+func mysqlDSN(db *dtype.Database) (string, error) {
+	address := fmt.Sprintf("tcp(%s:%s)", db.Host, db.Port)
+
+	if isNotEmpty(db.Socket) {
+		address = fmt.Sprintf("unix(%s)", db.Socket)
+	}
+
+	dsn := fmt.Sprintf("%s:%s@%s/%s",
+		db.Username, db.Password, address, db.DBName)
+
+	if isNotEmpty(db.SSLMode) && db.SSLMode != "disable" {
+		name, err := registerMySQLTLS(db)
+		if err != nil {
+			return "", err
+		}
+		dsn += "?tls=" + name
+	}
+
+	return dsn, nil
+}
+
+// sqliteDSN returns URI filename of SQLite database file with optional open flags
+func sqliteDSN(db *dtype.Database) (string, error) {
+	if isEmpty(db.Path) {
+		return "", fmt.Errorf("Path to SQLite database file is not defined")
+	}
+
+	params := url.Values{}
+
+	if db.ReadOnly {
+		params.Set("mode", "ro")
+	}
+
+	if db.Immutable {
+		params.Set("immutable", "1")
+	}
+
+	dsn := "file:" + db.Path
+	if len(params) > 0 {
+		dsn += "?" + params.Encode()
+	}
+
+	return dsn, nil
+}
+
+// mssqlDSN returns URL of SQL Server database, optionally with named instance, Windows domain of user,
+// encryption mode and application name
+func mssqlDSN(db *dtype.Database) (string, error) {
+	if isNotEmpty(db.Encrypt) && !mssqlEncrypt[db.Encrypt] {
+		return "", fmt.Errorf("SQL Server encryption mode %s is not supported", db.Encrypt)
+	}
+
+	username := db.Username
+	if isNotEmpty(db.Domain) {
+		// Windows authentication requires user in form DOMAIN\user
+		username = db.Domain + `\` + db.Username
+	}
+
+	host := db.Host
+	if isNotEmpty(db.Port) {
+		host += ":" + db.Port
+	}
+
+	params := url.Values{}
+
+	if isNotEmpty(db.DBName) {
+		params.Set("database", db.DBName)
+	}
+
+	if isNotEmpty(db.Encrypt) {
+		params.Set("encrypt", db.Encrypt)
+	}
+
+	if isNotEmpty(db.AppName) {
+		params.Set("app name", db.AppName)
+	}
+
+	dsn := url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(username, db.Password),
+		Host:     host,
+		Path:     db.Instance,
+		RawQuery: params.Encode(),
+	}
+
+	return dsn.String(), nil
+}
@@ -17,36 +17,271 @@ limitations under the License.
 package dtype
 
 import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/executor"
 )
 
+// State describes state of connection to the database
+type State int
+
+const (
+	// StateConnecting means that connection has not been established yet
+	StateConnecting State = iota
+	// StateHealthy means that connection is established and all queries succeed
+	StateHealthy
+	// StateDegraded means that connection is established, but some queries fail
+	StateDegraded
+	// StateDown means that connection cannot be established, next attempt to connect is scheduled
+	StateDown
+)
+
+// String returns name of the state
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateHealthy:
+		return "healthy"
+	case StateDegraded:
+		return "degraded"
+	case StateDown:
+		return "down"
+	}
+	return "unknown"
+}
+
 // Database holds connection information (driver, host, username etc.),
 // names of queries to perform and instance of executor which stores handle to db
 type Database struct {
 	Driver    string
 	Host      string
 	Port      string
+	Socket    string // path to Unix-domain socket (MySQL) or directory containing it (PostgreSQL)
 	Username  string
 	Password  string
 	DBName    string
 	SelectDB  string
+	Path      string // path to database file (SQLite)
+	ReadOnly  bool   // open database file in read-only mode (SQLite)
+	Immutable bool   // treat database file as immutable (SQLite)
+	Instance  string // name of server instance (SQL Server)
+	Domain    string // Windows domain of user (SQL Server)
+	Encrypt   string // encryption mode of connection (SQL Server)
+	AppName   string // application name reported to server (SQL Server)
+	DSN       string // data source name passed to sql driver as it is (overrides other connection options)
+
+	SSLMode       string // SSL mode of connection: disable, require, verify-ca or verify-full (PostgreSQL, MySQL)
+	SSLCA         string // path to CA bundle used to verify the server certificate
+	SSLCert       string // path to client certificate
+	SSLKey        string // path to client private key
+	SSLServerName string // server name expected in the server certificate (MySQL)
+
 	Executor  executor.Execution
-	Active    bool
-	QrsToExec []string // names of queries to be executed for the database
+	State     State     // state of connection to the database
+	Failures  int       // number of consecutive failed attempts to connect
+	NextRetry time.Time // time of the next attempt to connect when database is down
+	QrsToExec []string  // names of queries to be executed for the database
+
+	QueryArgs     map[string][]interface{} // arguments of queries defined for the database, keyed by names of queries
+	ServerVersion Version                  // version of database server, determined when needed by statements of queries (nil - unknown)
+
+	MaxOpenConns    int           // maximum number of open connections (0 - unlimited)
+	MaxIdleConns    int           // maximum number of idle connections (0 - default, negative - none)
+	ConnMaxLifetime time.Duration // maximum amount of time a connection may be reused (0 - unlimited)
+	PoolStats       bool          // expose statistics of connection pool as metrics
+
+	QueryTimeout time.Duration // default timeout of queries executed for the database (0 - none)
+	Concurrency  int           // maximum number of queries executed for the database at the same time
+
+	NullsSuppressed int64 // number of NULL values skipped since the configuration was loaded
 }
 
 // Query holds statement of the query and its results (there is one or more) which
-// structure defines how the returned data should be interpreted
+// structure defines how the returned data should be interpreted. Variants of statement
+// specific for drivers (and versions of servers) defined in `Statements` take precedence over `Statement`.
 type Query struct {
-	Statement string
-	Results   map[string]Result
+	Statement   string
+	Statements  map[string][]Statement // variants of statement keyed by names of drivers, the first matching one is used
+	Results     map[string]Result
+	Timeout     time.Duration // timeout of query execution, overrides default timeout of database (0 - none)
+	Args        []interface{} // default arguments bound to parameters of statement
+	Incremental *Incremental  // settings of incremental query (nil - query is not incremental)
+	Interval    time.Duration // minimum time between executions, samples are reused meanwhile (0 - executed at each collection)
+	CacheTTL    time.Duration // maximum age of reused samples, also when the query fails (at least Interval)
+}
+
+// Incremental holds settings of incremental query, whose named placeholder `Placeholder` (given in statement
+// as `:name`) is bound to the greatest value of column `Column` seen so far (high-water mark), starting with `Initial`
+type Incremental struct {
+	Column      string
+	Placeholder string
+	Initial     interface{}
+}
+
+// PlaceholderRegexp returns regular expression matching named placeholder in statement, the placeholder
+// itself is preceded by the first submatch (which excludes PostgreSQL casts such as `value::name`)
+func (inc *Incremental) PlaceholderRegexp() *regexp.Regexp {
+	return regexp.MustCompile(`(^|[^:]):` + regexp.QuoteMeta(inc.Placeholder) + `\b`)
+}
+
+// FindPlaceholders returns positions (start and end) of named placeholders in `statement`, placeholders
+// inside quoted segments (see QuotedMask) are ignored
+func (inc *Incremental) FindPlaceholders(statement string) [][]int {
+	quoted := QuotedMask(statement)
+	found := [][]int{}
+
+	for _, match := range inc.PlaceholderRegexp().FindAllStringSubmatchIndex(statement, -1) {
+		// placeholder begins after the first submatch
+		if start := match[3]; !quoted[start] {
+			found = append(found, []int{start, match[1]})
+		}
+	}
+
+	return found
+}
+
+// QuotedMask returns mask of bytes of `statement` which belong to quoted segments (including quotes): string
+// literals in single quotes and identifiers in double quotes or backticks, where quotes are escaped by doubling them
+func QuotedMask(statement string) []bool {
+	mask := make([]bool, len(statement))
+	var quote byte
+
+	for i := 0; i < len(statement); i++ {
+		c := statement[i]
+		switch {
+		case quote == 0:
+			if c == '\'' || c == '"' || c == '`' {
+				quote = c
+				mask[i] = true
+			}
+		case c == quote && i+1 < len(statement) && statement[i+1] == quote:
+			// doubled quote does not close the segment
+			mask[i], mask[i+1] = true, true
+			i++
+		default:
+			mask[i] = true
+			if c == quote {
+				quote = 0
+			}
+		}
+	}
+
+	return mask
+}
+
+// Statement holds variant of statement for servers whose version is at least `MinVersion`
+// and lower than `MaxVersion` (nil means that version is not limited)
+type Statement struct {
+	Statement  string
+	MinVersion Version
+	MaxVersion Version
+}
+
+// Matches returns true if version `v` is within range of versions of the statement
+func (s Statement) Matches(v Version) bool {
+	if s.MinVersion != nil && v.Compare(s.MinVersion) < 0 {
+		return false
+	}
+	if s.MaxVersion != nil && v.Compare(s.MaxVersion) >= 0 {
+		return false
+	}
+	return true
+}
+
+// Version holds numeric components of version, e.g. [9 6 5] for "9.6.5"
+type Version []int
+
+// versionRegex matches numeric components of version separated by dots
+var versionRegex = regexp.MustCompile(`\d+(\.\d+)*`)
+
+// ParseVersion returns version found in string `str`, such as "9.6", "5.7.22-log" or "PostgreSQL 10.4 (Debian)"
+func ParseVersion(str string) (Version, error) {
+	match := versionRegex.FindString(str)
+	if match == "" {
+		return nil, fmt.Errorf("`%s` does not contain version", str)
+	}
+
+	v := Version{}
+	for _, component := range strings.Split(match, ".") {
+		n, err := strconv.Atoi(component)
+		if err != nil {
+			return nil, fmt.Errorf("`%s` contains invalid version, err=%v", str, err)
+		}
+		v = append(v, n)
+	}
+	return v, nil
+}
+
+// Compare returns -1, 0 or +1 if version `v` is lower than, equal to or greater than `other`,
+// missing components are treated as zeros
+func (v Version) Compare(other Version) int {
+	for i := 0; i < len(v) || i < len(other); i++ {
+		a, b := 0, 0
+		if i < len(v) {
+			a = v[i]
+		}
+		if i < len(other) {
+			b = other[i]
+		}
+
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	}
+	return 0
+}
+
+// String returns version in dotted form
+func (v Version) String() string {
+	components := []string{}
+	for _, n := range v {
+		components = append(components, strconv.Itoa(n))
+	}
+	return strings.Join(components, ".")
 }
 
 // Result holds information specified the columns whose values will be used to
 // distinguish results defined by `InstanceFrom` (additionally prefix can be added)
 // or whose content will be used as the actual data dfined by `ValueFrom.
+// Values of columns listed in `TagsFrom` become tags of metrics (keyed by names of columns).
+// `Instances` declares static instances exposed in metrics catalog instead of dynamic elements.
+// Value of column `TimestampFrom` (if defined) becomes timestamp of metric.
+// Values are converted to `ValueType` if it is declared. NULL values are handled according to `NullPolicy`
+// ("skip", "default" - replaced by `NullDefault`, "nan" or "fail"), by default they are passed as they are.
 type Result struct {
-	InstanceFrom   string
+	InstanceFrom   []InstanceColumn
 	InstancePrefix string
 	ValueFrom      string
+	TagsFrom       []string
+	Instances      []string
+	TimestampFrom  string
+	ValueType      string
+	NullPolicy     string
+	NullDefault    interface{}
+	ValueMap       []ValueMapping // mapping of values to numbers, the first matching one is applied
+	Unmapped       *float64       // number published for values which do not match any mapping (nil - metric is skipped)
+	Kind           string         // kind of metric: gauge (default) or counter (monotonically increasing)
+	Output         string         // published output: value (default), delta or rate (per second) since the previous collection
+}
+
+// ValueMapping maps value equal to `From` or matching `Regex` (if defined) to number `To`
+type ValueMapping struct {
+	From  string
+	Regex *regexp.Regexp
+	To    float64
+}
+
+// InstanceColumn holds name of column whose values become an element of namespace,
+// optionally preceded by an element defined by `Prefix`
+type InstanceColumn struct {
+	Column string
+	Prefix string
 }
@@ -15,10 +15,14 @@ limitations under the License.
 package executor
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Execution is an interface for mocking purposes of sql functions like open(), ping(), exec(), close() etc.
@@ -26,14 +30,21 @@ type Execution interface {
 	Open(driverName, dataSourceName string) error
 	Close() error
 	Ping() error
-	SwitchToDB(dbName string) error
-	Query(name, statement string) (map[string][]interface{}, error)
+	SwitchToDB(statement string) error
+	Query(name, statement string, args ...interface{}) (map[string][]interface{}, map[string]string, error)
+	QueryContext(ctx context.Context, name, statement string, args ...interface{}) (map[string][]interface{}, map[string]string, error)
+	SetPool(maxOpen, maxIdle int, maxLifetime time.Duration)
+	Stats() sql.DBStats
 }
 
+// ErrTimeout is returned when execution of query is cancelled because its timeout is exceeded
+var ErrTimeout = errors.New("Execution of query exceeded timeout")
+
 // SQLExecutor keeps handle to sql database and map of prepared queries' statements
 type SQLExecutor struct {
 	handle *sql.DB
 	stmts  map[string]*sql.Stmt
+	mu     sync.Mutex // guards stmts, queries can be executed concurrently
 }
 
 // NewExecutor returns a pointer to SQLExecutor with initialized map of stmt
@@ -48,12 +59,20 @@ var NewExecutor = func() Execution {
 func (se *SQLExecutor) Open(driverName, dataSourceName string) error {
 	var err error
 	se.handle, err = sql.Open(driverName, dataSourceName)
+
+	// statements prepared for previously opened handle are not valid anymore
+	se.mu.Lock()
+	se.stmts = make(map[string]*sql.Stmt)
+	se.mu.Unlock()
 	return err
 }
 
 // Close closes the database, releasing any open resources. It is rare to Close a DB,
 // as the DB handle is meant to be long-lived and shared between many goroutines.
 func (se *SQLExecutor) Close() error {
+	if se.handle == nil {
+		return nil
+	}
 	return se.handle.Close()
 }
 
@@ -62,32 +81,72 @@ func (se *SQLExecutor) Ping() error {
 	return se.handle.Ping()
 }
 
-// SwitchToDB changes the database context to the specified database
-func (se *SQLExecutor) SwitchToDB(dbName string) error {
-	_, err := se.handle.Exec("USE " + dbName)
+// SwitchToDB changes the database context executing the statement (its syntax depends on SQL dialect, e.g. `USE <db>`)
+func (se *SQLExecutor) SwitchToDB(statement string) error {
+	_, err := se.handle.Exec(statement)
 	return err
 }
 
-// Query executes a query and returns its output in convenient format (as a map to its values where keys are the names of columns)
-func (se *SQLExecutor) Query(name, statement string) (map[string][]interface{}, error) {
-	rows, err := execQuery(se, name, statement)
-	defer rows.Close()
+// SetPool sets limits of connection pool: maximum number of open connections, maximum number of idle connections
+// (negative means no idle connections are retained) and maximum amount of time a connection may be reused;
+// zero values leave defaults of database/sql
+func (se *SQLExecutor) SetPool(maxOpen, maxIdle int, maxLifetime time.Duration) {
+	if maxOpen > 0 {
+		se.handle.SetMaxOpenConns(maxOpen)
+	}
+	if maxIdle != 0 {
+		se.handle.SetMaxIdleConns(maxIdle)
+	}
+	if maxLifetime > 0 {
+		se.handle.SetConnMaxLifetime(maxLifetime)
+	}
+}
+
+// Stats returns statistics of connection pool
+func (se *SQLExecutor) Stats() sql.DBStats {
+	return se.handle.Stats()
+}
+
+// Query executes a query with arguments `args` bound to its parameters and returns its output in convenient format
+// (as a map to its values where keys are the names of columns) together with kinds of values of its columns
+// (see ConvertText)
+func (se *SQLExecutor) Query(name, statement string, args ...interface{}) (map[string][]interface{}, map[string]string, error) {
+	return se.QueryContext(context.Background(), name, statement, args...)
+}
+
+// QueryContext executes a query like Query, but the execution is cancelled when context `ctx` is done,
+// ErrTimeout is returned when its deadline is exceeded
+func (se *SQLExecutor) QueryContext(ctx context.Context, name, statement string, args ...interface{}) (map[string][]interface{}, map[string]string, error) {
+	table, kinds, err := queryTable(ctx, se, name, statement, args)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return nil, nil, ErrTimeout
+	}
+	return table, kinds, err
+}
 
+// queryTable executes a query and reads all of returned rows into a map to columns' values,
+// kinds of values are read from types of columns
+func queryTable(ctx context.Context, se *SQLExecutor, name, statement string, args []interface{}) (map[string][]interface{}, map[string]string, error) {
+	rows, err := execQuery(ctx, se, name, statement, args)
 	if err != nil {
-		return nil, fmt.Errorf("Cannot execute query `%+v`, err=%+v", statement, err)
+		return nil, nil, fmt.Errorf("Cannot execute query `%+v`, err=%+v", statement, err)
 	}
+	defer rows.Close()
 
 	// get query output (rows) and parse it to map
 	cols, err := rows.Columns()
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if len(cols) == 0 {
-		return nil, errors.New("Invalid row does not contain columns")
+		return nil, nil, errors.New("Invalid row does not contain columns")
 	}
 
+	// types of columns are used to convert values returned as text, e.g. MySQL DECIMAL
+	kinds := columnKinds(rows, cols)
+
 	table := map[string][]interface{}{}
 	vals := make([]interface{}, len(cols))
 	valsPtrs := make([]interface{}, len(vals))
@@ -100,7 +159,7 @@ func (se *SQLExecutor) Query(name, statement string) (map[string][]interface{},
 	for rows.Next() {
 		err = rows.Scan(valsPtrs...)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		for i, val := range vals {
@@ -110,23 +169,101 @@ func (se *SQLExecutor) Query(name, statement string) (map[string][]interface{},
 		cnt++
 	} // end of row.Next()
 
-	return table, nil
+	// reading rows could be interrupted (e.g. query is cancelled)
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return table, kinds, nil
+}
+
+// columnKinds returns kinds of values ("int", "float" or "bool") of columns `cols` of `rows` deduced from
+// their database types, columns of unknown kind are omitted
+func columnKinds(rows *sql.Rows, cols []string) map[string]string {
+	kinds := map[string]string{}
+
+	types, err := rows.ColumnTypes()
+	if err != nil || len(types) != len(cols) {
+		return kinds
+	}
+
+	for i, col := range types {
+		// MySQL driver prefixes names of unsigned types
+		if kind := typeKinds[strings.TrimPrefix(strings.ToUpper(col.DatabaseTypeName()), "UNSIGNED ")]; kind != "" {
+			kinds[strings.ToLower(cols[i])] = kind
+		}
+	}
+
+	return kinds
+}
+
+// typeKinds maps names of database types of supported drivers to kinds of their values
+var typeKinds = map[string]string{
+	"TINYINT": "int", "SMALLINT": "int", "MEDIUMINT": "int", "INT": "int", "INTEGER": "int", "BIGINT": "int",
+	"INT2": "int", "INT4": "int", "INT8": "int", "YEAR": "int",
+	"DECIMAL": "float", "NUMERIC": "float", "FLOAT": "float", "DOUBLE": "float", "REAL": "float",
+	"FLOAT4": "float", "FLOAT8": "float", "MONEY": "float", "SMALLMONEY": "float",
+	"BOOL": "bool", "BOOLEAN": "bool",
+}
+
+// ConvertText converts value of column returned as text to number or boolean according to `kind` of column,
+// value is returned unchanged if it is not a text or cannot be converted
+func ConvertText(val interface{}, kind string) interface{} {
+	if kind == "" {
+		return val
+	}
+
+	var text string
+	switch v := val.(type) {
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	default:
+		return val
+	}
+
+	switch kind {
+	case "int":
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(text, 10, 64); err == nil {
+			return u
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f
+		}
+	case "bool":
+		if b, err := strconv.ParseBool(text); err == nil {
+			return b
+		}
+	}
+
+	return val
 }
 
 // execQuery creates a prepared statement and executes a query that returns rows (typically a SELECT statement)
-func execQuery(se *SQLExecutor, name, statement string) (*sql.Rows, error) {
-	var err error
+// with arguments `args` bound to its parameters
+func execQuery(ctx context.Context, se *SQLExecutor, name, statement string, args []interface{}) (*sql.Rows, error) {
+	se.mu.Lock()
+	stmt := se.stmts[name]
 
 	// if query statement is not prepared (do not occured in map), prepare it
-	if se.stmts[name] == nil {
+	if stmt == nil {
 		// preparing query statement is needed to use the newer protocol for MySQL driver
 		// which provides information about type of result's value (can be obtained by using reflection)
-		se.stmts[name], err = se.handle.Prepare(statement)
+		var err error
+		stmt, err = se.handle.PrepareContext(ctx, statement)
 		if err != nil {
-			se.stmts[name].Close()
+			se.mu.Unlock()
 			return nil, err
 		}
+		se.stmts[name] = stmt
 	}
-	// execute query, output data is returned as rows
-	return se.stmts[name].Query()
+	se.mu.Unlock()
+
+	// execute query with arguments bound to its parameters, output data is returned as rows
+	return stmt.QueryContext(ctx, args...)
 }
@@ -0,0 +1,243 @@
+// +build linux
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/dtype"
+)
+
+// queryKey returns key of query `queryName` executed for database `dbName`, which identifies its high-water mark
+// (for incremental query) and its cached samples
+func queryKey(dbName, queryName string) string {
+	return dbName + "/" + queryName
+}
+
+// mark returns high-water mark of incremental query `queryName` executed for database `dbName`,
+// initial value of the query if none has been seen yet
+func (dbiPlg *DbiPlugin) mark(dbName, queryName string, inc *dtype.Incremental) interface{} {
+	if mark, exist := dbiPlg.marks[queryKey(dbName, queryName)]; exist {
+		return mark
+	}
+	return inc.Initial
+}
+
+// bindMark replaces named placeholder of incremental query `inc` in `statement` with placeholder of parameter
+// of driver `driverName` and binds high-water mark `mark` to it, returns the statement and all its arguments
+func bindMark(statement string, args []interface{}, inc *dtype.Incremental, mark interface{}, driverName string) (string, []interface{}, error) {
+	driver, err := getDriver(driverName)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if driver.Placeholder == nil {
+		return "", nil, fmt.Errorf("SQL Driver %s does not support incremental queries", driverName)
+	}
+
+	bound := append([]interface{}{}, args...)
+	// placeholders which are not numbered are bound in order of their occurrence
+	positional := driver.Placeholder(1) == driver.Placeholder(2)
+	if !positional {
+		bound = append(bound, mark)
+	}
+
+	var buf bytes.Buffer
+	last := 0
+	for _, match := range inc.FindPlaceholders(statement) {
+		start := match[0]
+		buf.WriteString(statement[last:start])
+
+		if positional {
+			// mark follows arguments of all placeholders preceding it
+			i := countPlaceholders(statement[:start], driver.Placeholder(1)) + len(bound) - len(args)
+			if i > len(bound) {
+				i = len(bound)
+			}
+			bound = append(bound[:i], append([]interface{}{mark}, bound[i:]...)...)
+			buf.WriteString(driver.Placeholder(1))
+		} else {
+			buf.WriteString(driver.Placeholder(len(args) + 1))
+		}
+
+		last = match[1]
+	}
+	buf.WriteString(statement[last:])
+
+	return buf.String(), bound, nil
+}
+
+// countPlaceholders returns number of occurrences of `placeholder` in `statement` outside of its quoted segments
+func countPlaceholders(statement, placeholder string) int {
+	quoted := dtype.QuotedMask(statement)
+	count := 0
+
+	for i := strings.Index(statement, placeholder); i >= 0; {
+		if !quoted[i] {
+			count++
+		}
+		next := strings.Index(statement[i+len(placeholder):], placeholder)
+		if next < 0 {
+			break
+		}
+		i += len(placeholder) + next
+	}
+
+	return count
+}
+
+// highWaterMark returns the greatest of high-water mark `mark` and values of column `column` in output `out`
+// of incremental query, NULL values are ignored
+func highWaterMark(out map[string][]interface{}, column string, mark interface{}) (interface{}, error) {
+	values, exist := out[strings.ToLower(column)]
+	if !exist {
+		if len(out) > 0 {
+			return nil, fmt.Errorf("column %s of high-water mark is not returned", column)
+		}
+		// no rows returned
+		return mark, nil
+	}
+
+	for _, value := range values {
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+
+		if value != nil && compareMarks(value, mark) > 0 {
+			mark = value
+		}
+	}
+
+	return mark, nil
+}
+
+// compareMarks returns -1, 0 or +1 if high-water mark `a` is lower than, equal to or greater than `b`;
+// times and numbers are compared by value, other values as strings
+func compareMarks(a, b interface{}) int {
+	ta, aIsTime := a.(time.Time)
+	tb, bIsTime := b.(time.Time)
+	if aIsTime || bIsTime {
+		var err error
+		if !aIsTime {
+			if ta, err = parseTimestamp(a); err != nil {
+				return -1
+			}
+		}
+		if !bIsTime {
+			if tb, err = parseTimestamp(b); err != nil {
+				return 1
+			}
+		}
+
+		switch {
+		case ta.Before(tb):
+			return -1
+		case ta.After(tb):
+			return 1
+		}
+		return 0
+	}
+
+	fa, errA := toFloat(a)
+	fb, errB := toFloat(b)
+	if errA == nil && errB == nil {
+		switch {
+		case fa.(float64) < fb.(float64):
+			return -1
+		case fa.(float64) > fb.(float64):
+			return 1
+		}
+		return 0
+	}
+
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// loadMarks reads high-water marks of incremental queries from state file `path`, keyed by database and query;
+// no marks are returned if the path is empty or the file does not exist yet
+func loadMarks(path string) (map[string]interface{}, error) {
+	marks := map[string]interface{}{}
+	if isEmpty(path) {
+		return marks, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return marks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&marks); err != nil {
+		return nil, fmt.Errorf("Invalid structure of state file `%s`, err=%v", path, err)
+	}
+
+	for key, mark := range marks {
+		switch value := mark.(type) {
+		case json.Number:
+			if i, err := value.Int64(); err == nil {
+				marks[key] = i
+			} else if f, err := value.Float64(); err == nil {
+				marks[key] = f
+			}
+		case string:
+			// times are stored in RFC 3339 format
+			if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+				marks[key] = t
+			}
+		}
+	}
+
+	return marks, nil
+}
+
+// saveMarks writes high-water marks of incremental queries to state file `path`, the file is replaced
+// at once, so it is not corrupted if the plugin is stopped meanwhile
+func saveMarks(path string, marks map[string]interface{}) error {
+	data, err := json.MarshalIndent(marks, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,98 @@
+// +build linux
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbi
+
+import (
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/dtype"
+)
+
+// indexEntry associates static prefix of namespaces with the query of database which can produce them
+type indexEntry struct {
+	prefix    string // static part of namespace: /intel/dbi/<database>/<result name>/<instance prefix>
+	dbName    string
+	queryName string
+}
+
+// queryIndex holds prefixes of namespaces of all queries' results
+type queryIndex []indexEntry
+
+// newQueryIndex builds index of queries to be executed for each of databases `dbs`
+func newQueryIndex(dbs map[string]*dtype.Database, qrs map[string]*dtype.Query) queryIndex {
+	idx := queryIndex{}
+
+	for dbName, db := range dbs {
+		for _, queryName := range db.QrsToExec {
+			query, exist := qrs[queryName]
+			if !exist {
+				continue
+			}
+
+			for resName, res := range query.Results {
+				idx = append(idx, indexEntry{
+					prefix:    createNamespace(dbName, resName, res.InstancePrefix, ""),
+					dbName:    dbName,
+					queryName: queryName,
+				})
+			}
+		}
+	}
+
+	return idx
+}
+
+// selectQueries returns names of queries which have to be executed for each database to obtain metrics
+// with namespaces `nss`, which may contain wildcards (e.g. dynamic elements of instances)
+func (idx queryIndex) selectQueries(nss []string) map[string]map[string]bool {
+	selected := map[string]map[string]bool{}
+
+	for _, ns := range nss {
+		elements := splitNamespace(ns)
+
+		for _, e := range idx {
+			if matchPrefix(elements, splitNamespace(e.prefix)) {
+				addQuery(selected, e)
+			}
+		}
+	}
+
+	return selected
+}
+
+// matchPrefix returns true when namespace `ns` starts with `prefix`; wildcard `*` matches any element
+// of prefix, trailing wildcard matches also a few elements
+func matchPrefix(ns, prefix []string) bool {
+	for i := range prefix {
+		if i >= len(ns) {
+			return false
+		}
+		if ns[i] == "*" && i == len(ns)-1 {
+			return true
+		}
+		if ns[i] != "*" && ns[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// addQuery marks query of entry `e` as selected to be executed
+func addQuery(selected map[string]map[string]bool, e indexEntry) {
+	if _, exist := selected[e.dbName]; !exist {
+		selected[e.dbName] = map[string]bool{}
+	}
+	selected[e.dbName][e.queryName] = true
+}
@@ -0,0 +1,103 @@
+// +build linux
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbi
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/executor"
+)
+
+// queryJob holds query to be executed for the database and its output
+type queryJob struct {
+	dbName    string
+	queryName string
+	statement string        // statement of query chosen for the database
+	args      []interface{} // arguments bound to parameters of statement
+	timeout   time.Duration
+	out       map[string][]interface{}
+	kinds     map[string]string // kinds of values of columns of output deduced from their database types
+	err       error
+	done      time.Time // time of completion of query execution
+	cached    bool      // query is not executed, samples of its previous execution are reused
+}
+
+// runJobs executes queries of jobs concurrently and waits for all of them to complete; the number of queries
+// executed at the same time is limited by concurrency of the plugin and by concurrency of each database
+func (dbiPlg *DbiPlugin) runJobs(jobs []*queryJob) {
+	limit := make(chan struct{}, dbiPlg.concurrency)
+	dbLimits := map[string]chan struct{}{}
+
+	for _, job := range jobs {
+		if _, exist := dbLimits[job.dbName]; !exist {
+			concurrency := dbiPlg.databases[job.dbName].Concurrency
+			if concurrency < 1 {
+				concurrency = 1
+			}
+			dbLimits[job.dbName] = make(chan struct{}, concurrency)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		if job.cached {
+			continue
+		}
+		wg.Add(1)
+
+		go func(job *queryJob) {
+			defer wg.Done()
+
+			// acquire limit of database first, not to occupy limit of the plugin while waiting for it
+			dbLimit := dbLimits[job.dbName]
+			dbLimit <- struct{}{}
+			defer func() { <-dbLimit }()
+
+			limit <- struct{}{}
+			defer func() { <-limit }()
+
+			db := dbiPlg.databases[job.dbName]
+			query := dbiPlg.queries[job.queryName]
+
+			// timeout of query overrides default timeout of database
+			job.timeout = query.Timeout
+			if job.timeout == 0 {
+				job.timeout = db.QueryTimeout
+			}
+
+			job.out, job.kinds, job.err = executeQuery(db.Executor, job.queryName, job.statement, job.timeout, job.args...)
+			job.done = time.Now()
+		}(job)
+	}
+
+	wg.Wait()
+}
+
+// executeQuery executes query with arguments `args`, which is cancelled if it is not completed within `timeout` (if greater than 0)
+func executeQuery(exec executor.Execution, name, statement string, timeout time.Duration, args ...interface{}) (map[string][]interface{}, map[string]string, error) {
+	ctx := context.Background()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return exec.QueryContext(ctx, name, statement, args...)
+}
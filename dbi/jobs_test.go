@@ -0,0 +1,194 @@
+// +build linux,small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbi
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/dtype"
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/mock"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// inFlight counts queries executed at the same time by mocked executors
+type inFlight struct {
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+// slowMock is a mocked executor whose queries take some time, it records the number of queries executed at the same time
+type slowMock struct {
+	mcMock
+	counter *inFlight
+}
+
+func (sm *slowMock) QueryContext(ctx context.Context, name, statement string, args ...interface{}) (map[string][]interface{}, map[string]string, error) {
+	sm.counter.mu.Lock()
+	sm.counter.current++
+	if sm.counter.current > sm.counter.max {
+		sm.counter.max = sm.counter.current
+	}
+	sm.counter.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	sm.counter.mu.Lock()
+	sm.counter.current--
+	sm.counter.mu.Unlock()
+
+	return mockdata.QueryOutput, nil, nil
+}
+
+func TestConcurrentExecution(t *testing.T) {
+
+	// newPlugin returns plugin with databases whose executors are slowMock, there are three queries for each database
+	newPlugin := func(concurrency, dbConcurrency int) (*DbiPlugin, *inFlight) {
+		counter := &inFlight{}
+		dbiPlugin := New()
+		dbiPlugin.concurrency = concurrency
+		dbiPlugin.queries = map[string]*dtype.Query{
+			"q1": {Statement: "statementA", Results: map[string]dtype.Result{"r1": {InstanceFrom: []dtype.InstanceColumn{{Column: "category"}}, ValueFrom: "value"}}},
+			"q2": {Statement: "statementB", Results: map[string]dtype.Result{"r2": {InstanceFrom: []dtype.InstanceColumn{{Column: "category"}}, ValueFrom: "value"}}},
+			"q3": {Statement: "statementC", Results: map[string]dtype.Result{"r3": {InstanceFrom: []dtype.InstanceColumn{{Column: "category"}}, ValueFrom: "value"}}},
+		}
+
+		for _, dbName := range []string{"cinder", "nova", "neutron"} {
+			sm := &slowMock{counter: counter}
+			sm.mockExecution(nil, nil, nil, nil, nil, mockdata.QueryOutput)
+			dbiPlugin.databases[dbName] = &dtype.Database{
+				Driver:      "mysql",
+				Concurrency: dbConcurrency,
+				State:       dtype.StateHealthy,
+				QrsToExec:   []string{"q1", "q2", "q3"},
+				Executor:    sm,
+			}
+		}
+
+		return dbiPlugin, counter
+	}
+
+	Convey("executing queries concurrently", t, func() {
+
+		Convey("when concurrency of the plugin is limited", func() {
+			dbiPlugin, counter := newPlugin(2, 3)
+			data, err := dbiPlugin.executeQueries(nil)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 3*3*3)
+			So(counter.max, ShouldEqual, 2)
+		})
+
+		Convey("when concurrency of databases is limited", func() {
+			dbiPlugin, counter := newPlugin(10, 1)
+			data, err := dbiPlugin.executeQueries(nil)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 3*3*3)
+			So(counter.max, ShouldEqual, 3)
+		})
+
+		Convey("when queries are executed sequentially", func() {
+			dbiPlugin, counter := newPlugin(1, 1)
+			data, err := dbiPlugin.executeQueries(nil)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 3*3*3)
+			So(counter.max, ShouldEqual, 1)
+		})
+	})
+
+	Convey("detecting namespaces which are not unique", t, func() {
+		errs := map[string]bool{}
+
+		for i := 0; i < 10; i++ {
+			dbiPlugin, _ := newPlugin(10, 3)
+			// results of queries q2 and q3 of each database create the same namespaces
+			dbiPlugin.queries["q3"].Results = map[string]dtype.Result{"r2": {InstanceFrom: []dtype.InstanceColumn{{Column: "category"}}, ValueFrom: "value"}}
+
+			_, err := dbiPlugin.executeQueries(nil)
+			So(err, ShouldNotBeNil)
+			errs[err.Error()] = true
+		}
+
+		// conflict is detected deterministically
+		So(errs, ShouldHaveLength, 1)
+		So(errs, ShouldContainKey, "Namespace `/intel/dbi/cinder/r2/categoryA` has to be unique, but is not")
+	})
+}
+
+func TestSelectQueries(t *testing.T) {
+	dbiPlugin := New()
+	dbiPlugin.queries = map[string]*dtype.Query{
+		"q1": {Statement: "statementA", Results: map[string]dtype.Result{"": {InstanceFrom: []dtype.InstanceColumn{{Column: "category"}}, ValueFrom: "value"}}},
+		"q2": {Statement: "statementB", Results: map[string]dtype.Result{"r2": {InstanceFrom: []dtype.InstanceColumn{{Column: "category"}}, InstancePrefix: "prefix", ValueFrom: "value"}}},
+		"q3": {Statement: "statementC", Results: map[string]dtype.Result{"r3": {ValueFrom: "value"}}},
+	}
+
+	for _, dbName := range []string{"cinder", "nova"} {
+		mc := &mcMock{}
+		mc.mockExecution(nil, nil, nil, nil, nil, mockdata.QueryOutput)
+		dbiPlugin.databases[dbName] = &dtype.Database{
+			Driver:    "mysql",
+			State:     dtype.StateHealthy,
+			QrsToExec: []string{"q2", "q3"},
+			Executor:  mc,
+		}
+	}
+	dbiPlugin.databases["nova"].QrsToExec = []string{"q1", "q2", "q3"}
+	dbiPlugin.index = newQueryIndex(dbiPlugin.databases, dbiPlugin.queries)
+
+	Convey("selecting queries which produce requested metrics", t, func() {
+
+		Convey("when metric has instance prefix", func() {
+			selected := dbiPlugin.index.selectQueries([]string{"/intel/dbi/cinder/r2/prefix/categoryA"})
+			So(selected, ShouldResemble, map[string]map[string]bool{"cinder": {"q2": true}})
+
+			data, err := dbiPlugin.executeQueries(selected)
+			So(err, ShouldBeNil)
+			So(data, ShouldHaveLength, 3)
+			So(data, ShouldContainKey, "/intel/dbi/cinder/r2/prefix/categoryA")
+		})
+
+		Convey("when metric has no instance", func() {
+			selected := dbiPlugin.index.selectQueries([]string{"/intel/dbi/cinder/r3"})
+			So(selected, ShouldResemble, map[string]map[string]bool{"cinder": {"q3": true}})
+		})
+
+		Convey("when result has neither name nor instance prefix", func() {
+			selected := dbiPlugin.index.selectQueries([]string{"/intel/dbi/nova/r3", "/intel/dbi/nova/categoryB"})
+			So(selected, ShouldResemble, map[string]map[string]bool{"nova": {"q1": true, "q3": true}})
+		})
+
+		Convey("when metric is not produced by any query", func() {
+			selected := dbiPlugin.index.selectQueries([]string{"/intel/dbi/cinder/r4"})
+			So(selected, ShouldBeEmpty)
+		})
+
+		Convey("when metric is dynamic", func() {
+			selected := dbiPlugin.index.selectQueries([]string{"/intel/dbi/cinder/r2/prefix/*"})
+			So(selected, ShouldResemble, map[string]map[string]bool{"cinder": {"q2": true}})
+
+			selected = dbiPlugin.index.selectQueries([]string{"/intel/dbi/*/r3"})
+			So(selected, ShouldResemble, map[string]map[string]bool{"cinder": {"q3": true}, "nova": {"q1": true, "q3": true}})
+
+			selected = dbiPlugin.index.selectQueries([]string{"/intel/dbi/cinder/*"})
+			So(selected, ShouldResemble, map[string]map[string]bool{"cinder": {"q2": true, "q3": true}})
+		})
+	})
+}
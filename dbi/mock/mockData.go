@@ -66,4 +66,8 @@ var (
 
 	SetfileCorr   = "mock/corrMockSetfile.json"
 	SetfileIncorr = "mock/incorrMockSetfile.json"
+	SetfileSQLite = "mock/sqliteMockSetfile.json"
+
+	// SQLiteFileName is a path of SQLite database file used by SetfileSQLite
+	SQLiteFileName = "temp_sqlite.db"
 )
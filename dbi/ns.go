@@ -23,6 +23,9 @@ import (
 // nsPrefix is prefix of metrics namespace
 var nsPrefix = []string{"intel", "dbi"}
 
+// nsSelf is an element of namespace which distinguishes self-metrics of the plugin, i.e. /intel/dbi/<db>/self/...
+const nsSelf = "self"
+
 // notAllowedChars contains all not allowed chars in namespace
 var notAllowedChars = []string{" ", "-", "(", ")", "[", "]", "{", "}", ",", ";"}
 
@@ -59,6 +62,20 @@ func createNamespace(dbName, resultName, instancePrefix, instanceValue string) s
 	return validateNamespace(joinNamespace(ns))
 }
 
+// matchNamespace returns true when namespace `ns` matches `pattern`, whose elements equal to wildcard `*`
+// match any element of namespace
+func matchNamespace(pattern, ns []string) bool {
+	if len(pattern) != len(ns) {
+		return false
+	}
+	for i := range pattern {
+		if pattern[i] != "*" && pattern[i] != ns[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // validateNamespace removes not allowed chars from namespace
 func validateNamespace(str string) string {
 
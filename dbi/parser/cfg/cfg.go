@@ -16,6 +16,11 @@ limitations under the License.
 
 package cfg
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // To unmarshal JSON into a struct, structs have to contain exported fields
 
 type SQLConfig struct {
@@ -24,16 +29,111 @@ type SQLConfig struct {
 }
 
 type QueryType struct {
-	Name      string            `json:"name"`
-	Statement string            `json:"statement"`
-	Results   []QueryResultType `json:"results"`
+	Name        string                    `json:"name"`
+	Statement   string                    `json:"statement"`
+	Statements  map[string]StatementsType `json:"statements"`
+	Results     []QueryResultType         `json:"results"`
+	Timeout     string                    `json:"timeout"`
+	Args        []interface{}             `json:"args"`
+	Incremental *IncrementalType          `json:"incremental"`
+	Interval    string                    `json:"interval"`
+	CacheTTL    string                    `json:"cache_ttl"`
+}
+
+type IncrementalType struct {
+	Column      string      `json:"column"`
+	Placeholder string      `json:"placeholder"`
+	Initial     interface{} `json:"initial"`
+}
+
+type StatementType struct {
+	Statement  string `json:"statement"`
+	MinVersion string `json:"min_version"`
+	MaxVersion string `json:"max_version"`
+}
+
+// StatementsType holds variants of statement defined for a driver, which are given as a single statement
+// or as a list of objects with statement and range of versions of server
+type StatementsType []StatementType
+
+// UnmarshalJSON decodes variants of statement given in any of the accepted forms
+func (st *StatementsType) UnmarshalJSON(data []byte) error {
+	var statement string
+	if err := json.Unmarshal(data, &statement); err == nil {
+		*st = StatementsType{{Statement: statement}}
+		return nil
+	}
+
+	var variants []StatementType
+	if err := json.Unmarshal(data, &variants); err != nil {
+		return fmt.Errorf("statements have to be given as a statement or a list of objects with statement, min_version and max_version, got %s", data)
+	}
+
+	*st = variants
+	return nil
 }
 
 type QueryResultType struct {
-	ResultName     string `json:"name"`
-	InstanceFrom   string `json:"instance_from"`
-	InstancePrefix string `json:"instance_prefix"`
-	ValueFrom      string `json:"value_from"`
+	ResultName     string           `json:"name"`
+	InstanceFrom   InstanceFromType `json:"instance_from"`
+	InstancePrefix string           `json:"instance_prefix"`
+	ValueFrom      string           `json:"value_from"`
+	TagsFrom       []string         `json:"tags_from"`
+	Instances      []string         `json:"instances"`
+	TimestampFrom  string           `json:"timestamp_from"`
+	ValueType      string           `json:"value_type"`
+	NullPolicy     string           `json:"null_policy"`
+	NullDefault    interface{}      `json:"null_default"`
+	ValueMap       []ValueMapType   `json:"value_map"`
+	Unmapped       *float64         `json:"unmapped"`
+	Kind           string           `json:"kind"`
+	Output         string           `json:"output"`
+}
+
+type ValueMapType struct {
+	From  *string `json:"from"`
+	Regex string  `json:"regex"`
+	To    float64 `json:"to"`
+}
+
+type InstanceColumnType struct {
+	Column string `json:"column"`
+	Prefix string `json:"prefix"`
+}
+
+// InstanceFromType holds columns defined in `instance_from`, which is given as a name of single column
+// or as a list whose items are names of columns or objects with column and its prefix
+type InstanceFromType []InstanceColumnType
+
+// UnmarshalJSON decodes `instance_from` given in any of the accepted forms
+func (ift *InstanceFromType) UnmarshalJSON(data []byte) error {
+	var column string
+	if err := json.Unmarshal(data, &column); err == nil {
+		*ift = nil
+		if len(column) > 0 {
+			*ift = InstanceFromType{{Column: column}}
+		}
+		return nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("instance_from has to be a name of column or a list of columns, got %s", data)
+	}
+
+	columns := InstanceFromType{}
+	for _, item := range items {
+		ict := InstanceColumnType{}
+		if err := json.Unmarshal(item, &ict.Column); err != nil {
+			if err := json.Unmarshal(item, &ict); err != nil {
+				return fmt.Errorf("instance_from has invalid item %s, expected name of column or object with column and prefix", item)
+			}
+		}
+		columns = append(columns, ict)
+	}
+
+	*ift = columns
+	return nil
 }
 
 type DatabasesType struct {
@@ -42,16 +142,44 @@ type DatabasesType struct {
 	DriverOption   DriverOptionType `json:"driver_option"`
 	SelectDb       string           `json:"selectdb"`
 	QueryToExecute []DBQueryType    `json:"dbqueries"`
+	Pool           PoolType         `json:"pool"`
+	QueryTimeout   string           `json:"query_timeout"`
+	Concurrency    int              `json:"concurrency"`
+}
+
+type PoolType struct {
+	MaxOpenConns    int    `json:"max_open_conns"`
+	MaxIdleConns    int    `json:"max_idle_conns"`
+	ConnMaxLifetime string `json:"conn_max_lifetime"`
+	Stats           bool   `json:"stats"`
 }
 
 type DBQueryType struct {
-	QueryName string `json:"query"`
+	QueryName string        `json:"query"`
+	Args      []interface{} `json:"args"`
 }
 
 type DriverOptionType struct {
-	Host     string `json:"host"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	DbName   string `json:"dbname"`
-	Port     string `json:"port"`
+	Host      string `json:"host"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	PassFile  string `json:"password_file"`
+	PassEnv   string `json:"password_env"`
+	DbName    string `json:"dbname"`
+	Port      string `json:"port"`
+	Socket    string `json:"socket"`
+	Path      string `json:"path"`
+	ReadOnly  bool   `json:"readonly"`
+	Immutable bool   `json:"immutable"`
+	Instance  string `json:"instance"`
+	Domain    string `json:"domain"`
+	Encrypt   string `json:"encrypt"`
+	AppName   string `json:"app_name"`
+	DSN       string `json:"dsn"`
+
+	SSLMode       string `json:"ssl_mode"`
+	SSLCA         string `json:"ssl_ca"`
+	SSLCert       string `json:"ssl_cert"`
+	SSLKey        string `json:"ssl_key"`
+	SSLServerName string `json:"ssl_server_name"`
 }
@@ -20,14 +20,65 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/dtype"
 	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/executor"
 	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/parser/cfg"
 )
 
+// sslModes specifies supported SSL modes of connection
+var sslModes = map[string]bool{
+	"disable":     true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+// sslDrivers specifies SQL drivers which support SSL options
+var sslDrivers = map[string]bool{
+	"mysql":    true,
+	"postgres": true,
+}
+
+// valueTypes specifies supported types to which values of results are converted
+var valueTypes = map[string]bool{
+	"int":       true,
+	"float":     true,
+	"bool":      true,
+	"string":    true,
+	"duration":  true,
+	"timestamp": true,
+}
+
+// nullPolicies specifies supported policies of handling NULL values of results
+var nullPolicies = map[string]bool{
+	"skip":    true,
+	"default": true,
+	"nan":     true,
+	"fail":    true,
+}
+
+// kinds specifies supported kinds of metrics
+var kinds = map[string]bool{
+	"gauge":   true,
+	"counter": true,
+}
+
+// outputs specifies supported outputs of results, i.e. what is published: value, its delta or rate
+var outputs = map[string]bool{
+	"value": true,
+	"delta": true,
+	"rate":  true,
+}
+
+// placeholderName matches valid names of placeholders of incremental queries
+var placeholderName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 // Parser holds maps to queries and databases
 type Parser struct {
 	qrs map[string]*dtype.Query
@@ -94,10 +145,70 @@ func (p *Parser) addDatabase(dt cfg.DatabasesType) error {
 		return fmt.Errorf("Data name `%+s` is not unique", dt.Name)
 	}
 
+	// resolve references to environment variables and secrets
+	if err := expandDriverOption(dt.Name, &dt.DriverOption); err != nil {
+		return err
+	}
+
+	password, err := resolvePassword(dt.Name, dt.DriverOption)
+	if err != nil {
+		return err
+	}
+	dt.DriverOption.Password = password
+
+	if err := validateSSL(dt.Name, dt.Driver, dt.DriverOption); err != nil {
+		return err
+	}
+
+	connMaxLifetime, err := parseDuration(dt.Pool.ConnMaxLifetime)
+	if err != nil {
+		return fmt.Errorf("Database `%+s` has invalid conn_max_lifetime `%+s`, err=%+v", dt.Name, dt.Pool.ConnMaxLifetime, err)
+	}
+
+	queryTimeout, err := parseDuration(dt.QueryTimeout)
+	if err != nil {
+		return fmt.Errorf("Database `%+s` has invalid query_timeout `%+s`, err=%+v", dt.Name, dt.QueryTimeout, err)
+	}
+
+	if dt.Concurrency < 0 {
+		return fmt.Errorf("Database `%+s` has negative concurrency", dt.Name)
+	}
+
+	if dt.Pool.MaxOpenConns < 0 {
+		return fmt.Errorf("Database `%+s` has negative max_open_conns", dt.Name)
+	}
+
+	// switching to database affects only the connection which executed it, so queries cannot be spread
+	// over other connections of the pool
+	if len(dt.SelectDb) > 0 && dt.Concurrency > 1 {
+		return fmt.Errorf("Database `%+s` has selectdb, which cannot be combined with concurrency greater than 1", dt.Name)
+	}
+
+	// switched connection has to be retained in the pool
+	if len(dt.SelectDb) > 0 && (dt.Pool.MaxIdleConns < 0 || connMaxLifetime > 0) {
+		return fmt.Errorf("Database `%+s` has selectdb, which cannot be combined with negative max_idle_conns or conn_max_lifetime", dt.Name)
+	}
+
 	//getting info about which queries are to be executed
 	execQrs := []string{}
+	queryArgs := map[string][]interface{}{}
 	for _, q := range dt.QueryToExecute {
 		execQrs = append(execQrs, q.QueryName)
+
+		if query, exist := p.qrs[q.QueryName]; exist && len(query.Statements[dt.Driver]) == 0 && len(strings.TrimSpace(query.Statement)) == 0 {
+			return fmt.Errorf("Database `%+s` refers to query `%+s` which has no statement for driver `%+s`", dt.Name, q.QueryName, dt.Driver)
+		}
+
+		if q.Args == nil {
+			// default arguments of query are used
+			continue
+		}
+
+		args, err := parseArgs(q.Args)
+		if err != nil {
+			return fmt.Errorf("Database `%+s` has invalid args of query `%+s`, err=%+v", dt.Name, q.QueryName, err)
+		}
+		queryArgs[q.QueryName] = args
 	}
 
 	// adding database to databases map
@@ -105,13 +216,97 @@ func (p *Parser) addDatabase(dt cfg.DatabasesType) error {
 		Driver:    dt.Driver,
 		Host:      dt.DriverOption.Host,
 		Port:      dt.DriverOption.Port,
+		Socket:    dt.DriverOption.Socket,
 		Username:  dt.DriverOption.Username,
 		Password:  dt.DriverOption.Password,
 		DBName:    dt.DriverOption.DbName,
 		SelectDB:  dt.SelectDb,
-		Active:    false,
+		Path:      dt.DriverOption.Path,
+		ReadOnly:  dt.DriverOption.ReadOnly,
+		Immutable: dt.DriverOption.Immutable,
+		Instance:  dt.DriverOption.Instance,
+		Domain:    dt.DriverOption.Domain,
+		Encrypt:   dt.DriverOption.Encrypt,
+		AppName:   dt.DriverOption.AppName,
+		DSN:       dt.DriverOption.DSN,
+
+		SSLMode:       dt.DriverOption.SSLMode,
+		SSLCA:         dt.DriverOption.SSLCA,
+		SSLCert:       dt.DriverOption.SSLCert,
+		SSLKey:        dt.DriverOption.SSLKey,
+		SSLServerName: dt.DriverOption.SSLServerName,
+
+		State:     dtype.StateConnecting,
 		QrsToExec: execQrs,
+		QueryArgs: queryArgs,
 		Executor:  executor.NewExecutor(),
+
+		MaxOpenConns:    dt.Pool.MaxOpenConns,
+		MaxIdleConns:    dt.Pool.MaxIdleConns,
+		ConnMaxLifetime: connMaxLifetime,
+		PoolStats:       dt.Pool.Stats,
+
+		QueryTimeout: queryTimeout,
+		Concurrency:  dt.Concurrency,
+	}
+
+	return nil
+}
+
+// validateSSL checks SSL mode and whether files defined in SSL options of database `dbName` can be read;
+// SSL options are rejected when they would be ignored, i.e. for driver `driver` which does not support them
+// or when SSL is disabled
+func validateSSL(dbName, driver string, opt cfg.DriverOptionType) error {
+	tlsOptions := len(opt.SSLCA) > 0 || len(opt.SSLCert) > 0 || len(opt.SSLKey) > 0 || len(opt.SSLServerName) > 0
+
+	if (len(opt.SSLMode) > 0 || tlsOptions) && !sslDrivers[driver] {
+		return fmt.Errorf("Database `%+s` has SSL options which are not supported by SQL Driver %s", dbName, driver)
+	}
+
+	if len(opt.SSLMode) > 0 && !sslModes[opt.SSLMode] {
+		return fmt.Errorf("Database `%+s` has SSL mode `%+s` which is not supported", dbName, opt.SSLMode)
+	}
+
+	if tlsOptions && (len(opt.SSLMode) == 0 || opt.SSLMode == "disable") {
+		return fmt.Errorf("Database `%+s` has SSL options defined, but SSL is disabled (ssl_mode has to be set to enable SSL)", dbName)
+	}
+
+	if len(opt.SSLServerName) > 0 && driver != "mysql" {
+		return fmt.Errorf("Database `%+s` has ssl_server_name which is not supported by SQL Driver %s", dbName, driver)
+	}
+
+	if (len(opt.SSLCert) == 0) != (len(opt.SSLKey) == 0) {
+		return fmt.Errorf("Database `%+s` has to have both client certificate and key defined", dbName)
+	}
+
+	files := []struct {
+		option string
+		path   string
+	}{
+		{"ssl_ca", opt.SSLCA},
+		{"ssl_cert", opt.SSLCert},
+		{"ssl_key", opt.SSLKey},
+	}
+
+	for _, f := range files {
+		if len(f.path) == 0 {
+			continue
+		}
+
+		file, err := os.Open(f.path)
+		if err != nil {
+			return fmt.Errorf("Database `%+s` has %s file which cannot be read, err=%+v", dbName, f.option, err)
+		}
+		info, err := file.Stat()
+		file.Close()
+
+		if err != nil {
+			return fmt.Errorf("Database `%+s` has %s file which cannot be read, err=%+v", dbName, f.option, err)
+		}
+
+		if info.IsDir() {
+			return fmt.Errorf("Database `%+s` has %s `%+s` which is a directory", dbName, f.option, f.path)
+		}
 	}
 
 	return nil
@@ -128,6 +323,51 @@ func (p *Parser) addQuery(qt cfg.QueryType) error {
 		return fmt.Errorf("Query name `%+s` is not unique", qt.Name)
 	}
 
+	timeout, err := parseDuration(qt.Timeout)
+	if err != nil {
+		return fmt.Errorf("Query `%+s` has invalid timeout `%+s`, err=%+v", qt.Name, qt.Timeout, err)
+	}
+
+	args, err := parseArgs(qt.Args)
+	if err != nil {
+		return fmt.Errorf("Query `%+s` has invalid args, err=%+v", qt.Name, err)
+	}
+
+	interval, err := parseDuration(qt.Interval)
+	if err != nil {
+		return fmt.Errorf("Query `%+s` has invalid interval `%+s`, err=%+v", qt.Name, qt.Interval, err)
+	}
+
+	cacheTTL, err := parseDuration(qt.CacheTTL)
+	if err != nil {
+		return fmt.Errorf("Query `%+s` has invalid cache_ttl `%+s`, err=%+v", qt.Name, qt.CacheTTL, err)
+	}
+
+	if cacheTTL > 0 && interval == 0 {
+		return fmt.Errorf("Query `%+s` has cache_ttl, but no interval", qt.Name)
+	}
+	if cacheTTL > 0 && cacheTTL < interval {
+		return fmt.Errorf("Query `%+s` has cache_ttl shorter than interval", qt.Name)
+	}
+	if cacheTTL == 0 {
+		// samples are reused until the next execution of the query
+		cacheTTL = interval
+	}
+
+	statements := map[string][]dtype.Statement{}
+	for driver, variants := range qt.Statements {
+		parsed, err := parseStatements(variants)
+		if err != nil {
+			return fmt.Errorf("Query `%+s` has invalid statements for driver `%+s`, err=%+v", qt.Name, driver, err)
+		}
+		statements[driver] = parsed
+	}
+
+	incremental, err := parseIncremental(qt, statements)
+	if err != nil {
+		return fmt.Errorf("Query `%+s` has invalid incremental settings, err=%+v", qt.Name, err)
+	}
+
 	results := map[string]dtype.Result{}
 
 	for _, r := range qt.Results {
@@ -136,23 +376,274 @@ func (p *Parser) addQuery(qt cfg.QueryType) error {
 			return fmt.Errorf("Query `%+s` has result `%+s` which name is not unique", qt.Name, r.ResultName)
 		}
 
+		instanceFrom := []dtype.InstanceColumn{}
+		instances := map[string]bool{}
+		for _, ic := range r.InstanceFrom {
+			if len(strings.TrimSpace(ic.Column)) == 0 {
+				return fmt.Errorf("Query `%+s` has result `%+s` with empty column name in instance_from", qt.Name, r.ResultName)
+			}
+			if instances[ic.Column] {
+				return fmt.Errorf("Query `%+s` has result `%+s` with column `%+s` listed in instance_from more than once", qt.Name, r.ResultName, ic.Column)
+			}
+			instances[ic.Column] = true
+			instanceFrom = append(instanceFrom, dtype.InstanceColumn{Column: ic.Column, Prefix: ic.Prefix})
+		}
+
+		if len(r.Instances) > 0 && len(instanceFrom) == 0 {
+			return fmt.Errorf("Query `%+s` has result `%+s` with instances, but without instance_from", qt.Name, r.ResultName)
+		}
+		for _, instance := range r.Instances {
+			if len(strings.TrimSpace(instance)) == 0 {
+				return fmt.Errorf("Query `%+s` has result `%+s` with empty instance in instances", qt.Name, r.ResultName)
+			}
+		}
+
+		if len(r.ValueType) > 0 && !valueTypes[r.ValueType] {
+			return fmt.Errorf("Query `%+s` has result `%+s` with value_type `%+s` which is not supported", qt.Name, r.ResultName, r.ValueType)
+		}
+
+		if err := validateNullPolicy(r); err != nil {
+			return fmt.Errorf("Query `%+s` has result `%+s` with invalid NULL policy, err=%+v", qt.Name, r.ResultName, err)
+		}
+
+		valueMap, err := parseValueMap(r)
+		if err != nil {
+			return fmt.Errorf("Query `%+s` has result `%+s` with invalid value_map, err=%+v", qt.Name, r.ResultName, err)
+		}
+
+		if len(r.Kind) > 0 && !kinds[r.Kind] {
+			return fmt.Errorf("Query `%+s` has result `%+s` with kind `%+s` which is not supported", qt.Name, r.ResultName, r.Kind)
+		}
+
+		if len(r.Output) > 0 && !outputs[r.Output] {
+			return fmt.Errorf("Query `%+s` has result `%+s` with output `%+s` which is not supported", qt.Name, r.ResultName, r.Output)
+		}
+
+		tags := map[string]bool{}
+		for _, column := range r.TagsFrom {
+			if len(strings.TrimSpace(column)) == 0 {
+				return fmt.Errorf("Query `%+s` has result `%+s` with empty column name in tags_from", qt.Name, r.ResultName)
+			}
+			if tags[column] {
+				return fmt.Errorf("Query `%+s` has result `%+s` with column `%+s` listed in tags_from more than once", qt.Name, r.ResultName, column)
+			}
+			tags[column] = true
+		}
+
 		// add result to the map `results`
 		results[r.ResultName] = dtype.Result{
-			InstanceFrom:   r.InstanceFrom,
+			InstanceFrom:   instanceFrom,
 			InstancePrefix: r.InstancePrefix,
 			ValueFrom:      r.ValueFrom,
+			TagsFrom:       r.TagsFrom,
+			Instances:      r.Instances,
+			TimestampFrom:  r.TimestampFrom,
+			ValueType:      r.ValueType,
+			NullPolicy:     r.NullPolicy,
+			NullDefault:    r.NullDefault,
+			ValueMap:       valueMap,
+			Unmapped:       r.Unmapped,
+			Kind:           r.Kind,
+			Output:         r.Output,
 		}
 
 	} // end of range q.Results
 
 	// adding query to queries map
 	p.qrs[qt.Name] = &dtype.Query{
-		Statement: qt.Statement,
-		Results:   results,
+		Statement:   qt.Statement,
+		Statements:  statements,
+		Results:     results,
+		Timeout:     timeout,
+		Args:        args,
+		Incremental: incremental,
+		Interval:    interval,
+		CacheTTL:    cacheTTL,
+	}
+	return nil
+}
+
+// parseStatements returns variants of statement defined for a driver, ranges of versions of server are parsed
+func parseStatements(variants cfg.StatementsType) ([]dtype.Statement, error) {
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("no statement is given")
+	}
+
+	statements := []dtype.Statement{}
+	for _, v := range variants {
+		if len(strings.TrimSpace(v.Statement)) == 0 {
+			return nil, fmt.Errorf("statement is empty")
+		}
+
+		s := dtype.Statement{Statement: v.Statement}
+
+		if len(v.MinVersion) > 0 {
+			version, err := dtype.ParseVersion(v.MinVersion)
+			if err != nil {
+				return nil, fmt.Errorf("invalid min_version, err=%+v", err)
+			}
+			s.MinVersion = version
+		}
+
+		if len(v.MaxVersion) > 0 {
+			version, err := dtype.ParseVersion(v.MaxVersion)
+			if err != nil {
+				return nil, fmt.Errorf("invalid max_version, err=%+v", err)
+			}
+			s.MaxVersion = version
+		}
+
+		if s.MinVersion != nil && s.MaxVersion != nil && s.MinVersion.Compare(s.MaxVersion) >= 0 {
+			return nil, fmt.Errorf("min_version `%+s` has to be lower than max_version `%+s`", v.MinVersion, v.MaxVersion)
+		}
+
+		statements = append(statements, s)
+	}
+
+	return statements, nil
+}
+
+// parseIncremental returns settings of incremental query `qt` (nil if it is not incremental), its named placeholder
+// has to occur in all its statements `statements` and in default statement (if given)
+func parseIncremental(qt cfg.QueryType, statements map[string][]dtype.Statement) (*dtype.Incremental, error) {
+	if qt.Incremental == nil {
+		return nil, nil
+	}
+
+	if len(strings.TrimSpace(qt.Incremental.Column)) == 0 {
+		return nil, fmt.Errorf("column is empty")
+	}
+
+	if !placeholderName.MatchString(qt.Incremental.Placeholder) {
+		return nil, fmt.Errorf("placeholder `%+s` is not a valid name", qt.Incremental.Placeholder)
+	}
+
+	initial, err := parseArgs([]interface{}{qt.Incremental.Initial})
+	if err != nil {
+		return nil, fmt.Errorf("initial has to be a number, string or boolean")
 	}
+
+	inc := &dtype.Incremental{
+		Column:      qt.Incremental.Column,
+		Placeholder: qt.Incremental.Placeholder,
+		Initial:     initial[0],
+	}
+	if inc.Initial == nil {
+		inc.Initial = int64(0)
+	}
+
+	all := []string{}
+	if len(strings.TrimSpace(qt.Statement)) > 0 {
+		all = append(all, qt.Statement)
+	}
+	for _, variants := range statements {
+		for _, s := range variants {
+			all = append(all, s.Statement)
+		}
+	}
+
+	for _, statement := range all {
+		if len(inc.FindPlaceholders(statement)) == 0 {
+			return nil, fmt.Errorf("statement `%+s` does not contain placeholder `:%+s`", statement, inc.Placeholder)
+		}
+	}
+
+	return inc, nil
+}
+
+// validateNullPolicy checks if NULL policy of result `r` is supported and consistent with its other settings
+func validateNullPolicy(r cfg.QueryResultType) error {
+	if len(r.NullPolicy) > 0 && !nullPolicies[r.NullPolicy] {
+		return fmt.Errorf("null_policy `%+s` is not supported", r.NullPolicy)
+	}
+
+	switch r.NullDefault.(type) {
+	case nil:
+		if r.NullPolicy == "default" {
+			return fmt.Errorf("null_default has to be given for null_policy `default`")
+		}
+	case float64, string, bool:
+		if r.NullPolicy != "default" {
+			return fmt.Errorf("null_default can be given only for null_policy `default`")
+		}
+	default:
+		return fmt.Errorf("null_default has to be a number, string or boolean")
+	}
+
+	if r.NullPolicy == "nan" && len(r.ValueType) > 0 && r.ValueType != "float" {
+		return fmt.Errorf("null_policy `nan` requires value_type `float`, got `%+s`", r.ValueType)
+	}
+
 	return nil
 }
 
+// parseValueMap returns mappings of values defined for result `r`, regular expressions are compiled
+func parseValueMap(r cfg.QueryResultType) ([]dtype.ValueMapping, error) {
+	if r.Unmapped != nil && len(r.ValueMap) == 0 {
+		return nil, fmt.Errorf("unmapped can be given only together with value_map")
+	}
+
+	mappings := []dtype.ValueMapping{}
+	for _, vm := range r.ValueMap {
+		if (vm.From == nil) == (len(vm.Regex) == 0) {
+			return nil, fmt.Errorf("exactly one of from and regex has to be given for each mapping")
+		}
+
+		if vm.From != nil {
+			mappings = append(mappings, dtype.ValueMapping{From: *vm.From, To: vm.To})
+			continue
+		}
+
+		regex, err := regexp.Compile(vm.Regex)
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, dtype.ValueMapping{Regex: regex, To: vm.To})
+	}
+
+	return mappings, nil
+}
+
+// parseArgs returns arguments of query `args` which have to be scalars, integral numbers are converted to integers
+func parseArgs(args []interface{}) ([]interface{}, error) {
+	parsed := []interface{}{}
+
+	for i, arg := range args {
+		switch value := arg.(type) {
+		case float64:
+			if value == math.Trunc(value) && math.Abs(value) < 1<<53 {
+				parsed = append(parsed, int64(value))
+			} else {
+				parsed = append(parsed, value)
+			}
+		case string, bool, nil:
+			parsed = append(parsed, value)
+		default:
+			return nil, fmt.Errorf("argument %d has to be a number, string, boolean or null", i+1)
+		}
+	}
+
+	return parsed, nil
+}
+
+// parseDuration parses non-negative duration string such as "30s" or "1m30s", empty string means no duration
+func parseDuration(str string) (time.Duration, error) {
+	if len(str) == 0 {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, err
+	}
+
+	if d < 0 {
+		return 0, fmt.Errorf("duration `%+s` is negative", str)
+	}
+
+	return d, nil
+}
+
 // expandFileName replaces name of environment variable with its value and returns expanded filename
 func expandFileName(fName string) string {
 
@@ -0,0 +1,342 @@
+// +build linux,small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/dtype"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// parse writes setfile with `queries` and `databases` (given as JSON arrays) to a temporary file and parses it
+func parse(queries, databases string) (map[string]*dtype.Database, map[string]*dtype.Query, error) {
+	dir, err := ioutil.TempDir("", "dbi-parser")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	setfile := filepath.Join(dir, "setfile.json")
+	content := fmt.Sprintf(`{"queries": %s, "databases": %s}`, queries, databases)
+	if err := ioutil.WriteFile(setfile, []byte(content), 0600); err != nil {
+		return nil, nil, err
+	}
+
+	return GetDBItemsFromConfig(setfile)
+}
+
+// query is a valid query which the databases in tests refer to
+const query = `[{"name": "q1", "statement": "select host, value from services",
+	"results": [{"name": "value", "value_from": "value", "instance_from": "host"}]}]`
+
+// database is a valid database which executes query `q1`
+const database = `[{"name": "db1", "driver": "mysql", "dbqueries": [{"query": "q1"}]}]`
+
+func TestDatabases(t *testing.T) {
+
+	Convey("validating databases", t, func() {
+		Convey("when database is valid", func() {
+			dbs, _, err := parse(query, `[{"name": "db1", "driver": "mysql", "selectdb": "app", "dbqueries": [{"query": "q1"}]}]`)
+			So(err, ShouldBeNil)
+			So(dbs["db1"].SelectDB, ShouldEqual, "app")
+		})
+
+		Convey("when pool settings are valid", func() {
+			dbs, _, err := parse(query, `[{"name": "db1", "driver": "mysql",
+				"pool": {"max_open_conns": 3, "max_idle_conns": 1, "conn_max_lifetime": "5m", "stats": true}, "dbqueries": [{"query": "q1"}]}]`)
+			So(err, ShouldBeNil)
+
+			db := dbs["db1"]
+			So(db.MaxOpenConns, ShouldEqual, 3)
+			So(db.MaxIdleConns, ShouldEqual, 1)
+			So(db.ConnMaxLifetime, ShouldEqual, 5*time.Minute)
+			So(db.PoolStats, ShouldBeTrue)
+		})
+
+		Convey("when default timeout of queries is valid", func() {
+			dbs, _, err := parse(query, `[{"name": "db1", "driver": "mysql", "query_timeout": "1m", "dbqueries": [{"query": "q1"}]}]`)
+			So(err, ShouldBeNil)
+			So(dbs["db1"].QueryTimeout, ShouldEqual, time.Minute)
+		})
+
+		Convey("when arguments of queries are valid", func() {
+			dbs, _, err := parse(query, `[{"name": "db1", "driver": "mysql", "dbqueries": [{"query": "q1", "args": ["east", 1]}]}]`)
+			So(err, ShouldBeNil)
+			So(dbs["db1"].QueryArgs["q1"], ShouldResemble, []interface{}{"east", int64(1)})
+		})
+
+		Convey("when database is invalid", func() {
+			for _, db := range []string{
+				`{"name": "db1", "driver": "mysql", "pool": {"conn_max_lifetime": "5 minutes"}}`,
+				`{"name": "db1", "driver": "mysql", "pool": {"max_open_conns": -1}}`,
+				`{"name": "db1", "driver": "mysql", "query_timeout": "-1s"}`,
+				`{"name": "db1", "driver": "mysql", "dbqueries": [{"query": "q1", "args": [{"region": "east"}, 1]}]}`,
+				// only one connection is switched to selected database
+				`{"name": "db1", "driver": "mysql", "selectdb": "app", "concurrency": 2}`,
+				`{"name": "db1", "driver": "mysql", "selectdb": "app", "pool": {"max_idle_conns": -1}}`,
+				`{"name": "db1", "driver": "mysql", "selectdb": "app", "pool": {"conn_max_lifetime": "5m"}}`,
+			} {
+				_, _, err := parse(query, "["+db+"]")
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestQueries(t *testing.T) {
+
+	Convey("validating queries", t, func() {
+		Convey("when timeout is valid", func() {
+			_, qrs, err := parse(`[{"name": "q1", "statement": "select 1", "timeout": "50ms"}]`, database)
+			So(err, ShouldBeNil)
+			So(qrs["q1"].Timeout, ShouldEqual, 50*time.Millisecond)
+		})
+
+		Convey("when arguments are valid", func() {
+			_, qrs, err := parse(`[{"name": "q1", "statement": "select 1 where ? >= ?", "args": ["west", 3]}]`, database)
+			So(err, ShouldBeNil)
+			So(qrs["q1"].Args, ShouldResemble, []interface{}{"west", int64(3)})
+		})
+
+		Convey("when statements are valid", func() {
+			_, qrs, err := parse(`[{"name": "q1", "statement": "select 1",
+				"statements": {"mysql": [{"statement": "select 2", "max_version": "5.7"}, {"statement": "select 3", "min_version": "5.7"}]}}]`, database)
+			So(err, ShouldBeNil)
+			So(qrs["q1"].Statements["mysql"], ShouldHaveLength, 2)
+			So(qrs["q1"].Statements["mysql"][1].MinVersion, ShouldResemble, dtype.Version{5, 7})
+		})
+
+		Convey("when incremental query is valid", func() {
+			_, qrs, err := parse(`[{"name": "q1", "statement": "select max(id) as last_id from events where id > :last_id",
+				"incremental": {"column": "last_id", "placeholder": "last_id", "initial": 1}}]`, database)
+			So(err, ShouldBeNil)
+			So(qrs["q1"].Incremental.Column, ShouldEqual, "last_id")
+			So(qrs["q1"].Incremental.Placeholder, ShouldEqual, "last_id")
+		})
+
+		Convey("when interval is valid", func() {
+			_, qrs, err := parse(`[{"name": "q1", "statement": "select 1", "interval": "1h"}]`, database)
+			So(err, ShouldBeNil)
+			So(qrs["q1"].Interval, ShouldEqual, time.Hour)
+			// samples are reused until the next execution by default
+			So(qrs["q1"].CacheTTL, ShouldEqual, time.Hour)
+
+			_, qrs, err = parse(`[{"name": "q1", "statement": "select 1", "interval": "1h", "cache_ttl": "2h"}]`, database)
+			So(err, ShouldBeNil)
+			So(qrs["q1"].CacheTTL, ShouldEqual, 2*time.Hour)
+		})
+
+		Convey("when query is invalid", func() {
+			for _, q := range []string{
+				`{"name": "q1", "statement": "select 1", "timeout": "soon"}`,
+				`{"name": "q1", "statement": "select 1 where ? >= ?", "args": ["west", [1]]}`,
+				// database refers to query without statement for its driver
+				`{"name": "q1", "statements": {"postgres": "select 1"}}`,
+				`{"name": "q1", "statements": {"mysql": [{"statement": "select 1", "min_version": "3.8", "max_version": "3.1"}]}}`,
+				// statement does not contain placeholder
+				`{"name": "q1", "statement": "select max(id) as last_id from events where id > :last_id",
+					"incremental": {"column": "last_id", "placeholder": "last"}}`,
+				`{"name": "q1", "statement": "select max(id) as last_id from events where id > :last_id",
+					"incremental": {"column": "last_id", "placeholder": "last-id"}}`,
+				`{"name": "q1", "statement": "select max(id) as last_id from events where tag = ':last_id'",
+					"incremental": {"column": "last_id", "placeholder": "last_id"}}`,
+				`{"name": "q1", "statement": "select 1", "cache_ttl": "1h"}`,
+				`{"name": "q1", "statement": "select 1", "interval": "1h", "cache_ttl": "30m"}`,
+			} {
+				_, _, err := parse("["+q+"]", database)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestResults(t *testing.T) {
+
+	// parseResult parses query `q1` with result `r` (given as JSON object) and returns its result `value`
+	parseResult := func(r string) (dtype.Result, error) {
+		_, qrs, err := parse(`[{"name": "q1", "statement": "select host, value from services", "results": [`+r+`]}]`, database)
+		if err != nil {
+			return dtype.Result{}, err
+		}
+		return qrs["q1"].Results["value"], nil
+	}
+
+	Convey("validating results", t, func() {
+		Convey("when tags_from is valid", func() {
+			res, err := parseResult(`{"name": "value", "value_from": "value", "tags_from": ["host", "category"]}`)
+			So(err, ShouldBeNil)
+			So(res.TagsFrom, ShouldResemble, []string{"host", "category"})
+		})
+
+		Convey("when instance_from is valid", func() {
+			res, err := parseResult(`{"name": "value", "value_from": "value", "instance_from": "binary"}`)
+			So(err, ShouldBeNil)
+			So(res.InstanceFrom, ShouldResemble, []dtype.InstanceColumn{{Column: "binary"}})
+
+			res, err = parseResult(`{"name": "value", "value_from": "value", "instance_from": [{"column": "host", "prefix": "host"}, "binary"]}`)
+			So(err, ShouldBeNil)
+			So(res.InstanceFrom, ShouldResemble, []dtype.InstanceColumn{{Column: "host", Prefix: "host"}, {Column: "binary"}})
+		})
+
+		Convey("when instances are valid", func() {
+			res, err := parseResult(`{"name": "value", "value_from": "value", "instance_from": "metric", "instances": ["backup/up", "backup/down"]}`)
+			So(err, ShouldBeNil)
+			So(res.Instances, ShouldResemble, []string{"backup/up", "backup/down"})
+		})
+
+		Convey("when value_type is valid", func() {
+			res, err := parseResult(`{"name": "value", "value_from": "value", "value_type": "int"}`)
+			So(err, ShouldBeNil)
+			So(res.ValueType, ShouldEqual, "int")
+		})
+
+		Convey("when NULL policy is valid", func() {
+			res, err := parseResult(`{"name": "value", "value_from": "value", "null_policy": "default", "null_default": 0}`)
+			So(err, ShouldBeNil)
+			So(res.NullPolicy, ShouldEqual, "default")
+			So(res.NullDefault, ShouldEqual, float64(0))
+		})
+
+		Convey("when value_map is valid", func() {
+			res, err := parseResult(`{"name": "value", "value_from": "value", "value_map": [{"from": "Primary", "to": 1}, {"regex": "^Non", "to": 2}], "unmapped": -1}`)
+			So(err, ShouldBeNil)
+			So(res.ValueMap, ShouldHaveLength, 2)
+			So(res.Unmapped, ShouldNotBeNil)
+			So(*res.Unmapped, ShouldEqual, float64(-1))
+		})
+
+		Convey("when output is valid", func() {
+			res, err := parseResult(`{"name": "value", "value_from": "value", "kind": "counter", "output": "rate"}`)
+			So(err, ShouldBeNil)
+			So(res.Kind, ShouldEqual, "counter")
+			So(res.Output, ShouldEqual, "rate")
+		})
+
+		Convey("when result is invalid", func() {
+			for _, r := range []string{
+				`{"name": "value", "value_from": "value", "tags_from": ["host", "host"]}`,
+				`{"name": "value", "value_from": "value", "tags_from": [""]}`,
+				`{"name": "value", "value_from": "value", "instance_from": 1}`,
+				`{"name": "value", "value_from": "value", "instance_from": [1]}`,
+				`{"name": "value", "value_from": "value", "instance_from": ["host", "host"]}`,
+				`{"name": "value", "value_from": "value", "instance_from": [""]}`,
+				`{"name": "value", "value_from": "value", "instance_from": [{"prefix": "host"}]}`,
+				`{"name": "value", "value_from": "value", "instance_from": "host", "instances": ["node1", ""]}`,
+				`{"name": "value", "value_from": "value", "value_type": "integer"}`,
+				`{"name": "value", "value_from": "value", "null_policy": "ignore"}`,
+				`{"name": "value", "value_from": "value", "null_policy": "default"}`,
+				`{"name": "value", "value_from": "value", "null_policy": "skip", "null_default": 0}`,
+				`{"name": "value", "value_from": "value", "null_policy": "default", "null_default": [0]}`,
+				`{"name": "value", "value_from": "value", "null_policy": "nan", "value_type": "int"}`,
+				`{"name": "value", "value_from": "value", "value_map": [{"to": 1}]}`,
+				`{"name": "value", "value_from": "value", "value_map": [{"from": "Primary", "regex": "^Primary$", "to": 1}]}`,
+				`{"name": "value", "value_from": "value", "value_map": [{"regex": "(", "to": 1}]}`,
+				`{"name": "value", "value_from": "value", "unmapped": 0}`,
+				`{"name": "value", "value_from": "value", "kind": "counter", "output": "average"}`,
+			} {
+				_, err := parseResult(r)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestExamples(t *testing.T) {
+
+	Convey("parsing example setfiles", t, func() {
+		for _, setfile := range []string{"dbi_nova_cluster_status.json", "dbi_openstack.json"} {
+			_, qrs, err := GetDBItemsFromConfig(filepath.Join("..", "..", "examples", "configs", "setfiles", setfile))
+			So(err, ShouldBeNil)
+			So(qrs, ShouldNotBeEmpty)
+		}
+	})
+}
+
+func TestSecrets(t *testing.T) {
+
+	Convey("resolving secrets of setfile", t, func() {
+		dir, err := ioutil.TempDir("", "dbi-secrets")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		parseDB := func(driverOption string) (*dtype.Database, error) {
+			dbs, _, err := parse(query, `[{"name": "db1", "driver": "mysql", "driver_option": `+driverOption+`, "dbqueries": [{"query": "q1"}]}]`)
+			if err != nil {
+				return nil, err
+			}
+			return dbs["db1"], nil
+		}
+
+		os.Setenv("DBI_TEST_HOST", "db.example.com")
+		os.Setenv("DBI_TEST_PASSWORD", "s3cr3t")
+		defer os.Unsetenv("DBI_TEST_HOST")
+		defer os.Unsetenv("DBI_TEST_PASSWORD")
+
+		Convey("when driver options refer to environment variables", func() {
+			db, err := parseDB(`{"host": "${DBI_TEST_HOST}", "username": "user_${DBI_TEST_HOST}", "password": "${DBI_TEST_PASSWORD}"}`)
+			So(err, ShouldBeNil)
+			So(db.Host, ShouldEqual, "db.example.com")
+			So(db.Username, ShouldEqual, "user_db.example.com")
+			So(db.Password, ShouldEqual, "s3cr3t")
+		})
+
+		Convey("when driver option refers to environment variable which is not set", func() {
+			_, err := parseDB(`{"host": "${DBI_TEST_MISSING}"}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "DBI_TEST_MISSING")
+			So(err.Error(), ShouldContainSubstring, "host")
+		})
+
+		Convey("when password is given in environment variable", func() {
+			db, err := parseDB(`{"password_env": "DBI_TEST_PASSWORD"}`)
+			So(err, ShouldBeNil)
+			So(db.Password, ShouldEqual, "s3cr3t")
+
+			_, err = parseDB(`{"password_env": "DBI_TEST_MISSING"}`)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("when password is given in file", func() {
+			passFile := filepath.Join(dir, "password")
+			So(ioutil.WriteFile(passFile, []byte("s3cr3t\n"), 0600), ShouldBeNil)
+
+			db, err := parseDB(fmt.Sprintf(`{"password_file": "%s"}`, passFile))
+			So(err, ShouldBeNil)
+			So(db.Password, ShouldEqual, "s3cr3t")
+
+			_, err = parseDB(fmt.Sprintf(`{"password_file": "%s"}`, filepath.Join(dir, "missing")))
+			So(err, ShouldNotBeNil)
+
+			So(ioutil.WriteFile(passFile, []byte("\n"), 0600), ShouldBeNil)
+			_, err = parseDB(fmt.Sprintf(`{"password_file": "%s"}`, passFile))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("when password is defined more than once", func() {
+			_, err := parseDB(`{"password": "s3cr3t", "password_env": "DBI_TEST_PASSWORD"}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldNotContainSubstring, "s3cr3t")
+		})
+	})
+}
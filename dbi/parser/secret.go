@@ -0,0 +1,101 @@
+// +build linux
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/parser/cfg"
+)
+
+// envVarRef matches reference to environment variable in form of ${VAR}
+var envVarRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandDriverOption replaces references to environment variables in form of ${VAR} with their values
+// in every string field of driver option of database `dbName`
+func expandDriverOption(dbName string, opt *cfg.DriverOptionType) error {
+	v := reflect.ValueOf(opt).Elem()
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() != reflect.String {
+			continue
+		}
+
+		var missing []string
+		expanded := envVarRef.ReplaceAllStringFunc(field.String(), func(ref string) string {
+			name := envVarRef.FindStringSubmatch(ref)[1]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				missing = append(missing, name)
+			}
+			return value
+		})
+
+		if len(missing) > 0 {
+			option := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+			return fmt.Errorf("Database `%+s` has driver option `%+s` which refers to environment variable(s) not set: %s",
+				dbName, option, strings.Join(missing, ", "))
+		}
+		field.SetString(expanded)
+	}
+
+	return nil
+}
+
+// resolvePassword returns password of database `dbName` defined literally, read from file or from environment variable
+func resolvePassword(dbName string, opt cfg.DriverOptionType) (string, error) {
+	defined := 0
+	for _, option := range []string{opt.Password, opt.PassFile, opt.PassEnv} {
+		if len(option) > 0 {
+			defined++
+		}
+	}
+
+	if defined > 1 {
+		return "", fmt.Errorf("Database `%+s` has to have only one of password, password_file and password_env defined", dbName)
+	}
+
+	switch {
+	case len(opt.PassFile) > 0:
+		data, err := ioutil.ReadFile(opt.PassFile)
+		if err != nil {
+			return "", fmt.Errorf("Database `%+s` has password file which cannot be read, err=%+v", dbName, err)
+		}
+
+		// trailing new line is not a part of password
+		password := strings.TrimRight(string(data), "\r\n")
+		if len(password) == 0 {
+			return "", fmt.Errorf("Database `%+s` has password file `%+s` which is empty", dbName, opt.PassFile)
+		}
+		return password, nil
+
+	case len(opt.PassEnv) > 0:
+		password, ok := os.LookupEnv(opt.PassEnv)
+		if !ok {
+			return "", fmt.Errorf("Database `%+s` has password in environment variable `%+s` which is not set", dbName, opt.PassEnv)
+		}
+		return password, nil
+	}
+
+	return opt.Password, nil
+}
@@ -0,0 +1,234 @@
+// +build linux
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbi
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/dtype"
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/executor"
+)
+
+// namedSample is a sample of metric together with its name, kind and output of result which it comes from
+type namedSample struct {
+	name   string
+	kind   string
+	output string
+	sample
+}
+
+// jobOutput returns samples of query `job` which succeeded and high-water mark of its output (if query is incremental),
+// NULL values are counted only if `advance` is true (see jobSamples)
+func (dbiPlg *DbiPlugin) jobOutput(job *queryJob, query *dtype.Query, advance bool) ([]namedSample, interface{}, error) {
+	named, err := dbiPlg.jobSamples(job, advance)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if query.Incremental == nil {
+		return named, nil, nil
+	}
+
+	mark, err := highWaterMark(job.out, query.Incremental.Column, dbiPlg.mark(job.dbName, job.queryName, query.Incremental))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return named, mark, nil
+}
+
+// jobSamples returns samples of metrics obtained from results of executed query `job` in deterministic order
+// (sorted by names of results, then in order of rows); error is returned when the query has to be considered
+// as failed, i.e. it returned NULL value for result with `fail` NULL policy; skipped NULL values are counted
+// only if `advance` is true, so that samples which are not published (e.g. obtained to build the catalog) are ignored
+func (dbiPlg *DbiPlugin) jobSamples(job *queryJob, advance bool) ([]namedSample, error) {
+	named := []namedSample{}
+	db := dbiPlg.databases[job.dbName]
+	out := job.out
+	results := dbiPlg.queries[job.queryName].Results
+
+	resNames := []string{}
+	for resName := range results {
+		resNames = append(resNames, resName)
+	}
+	sort.Strings(resNames)
+
+	for _, resName := range resNames {
+		res := results[resName]
+		// to avoid inconsistency of columns names caused by capital letters (especially for postgresql driver)
+		valueFrom := strings.ToLower(res.ValueFrom)
+
+		instanceColumns := []string{}
+		for _, ic := range res.InstanceFrom {
+			instanceColumns = append(instanceColumns, ic.Column)
+		}
+
+		if column, ok := checkColumns(out, instanceColumns, len(out[valueFrom])); !ok {
+			// log result whose instances cannot be obtained and take the next one
+			fmt.Fprintf(os.Stderr, "Cannot get instances of result %s of query %s for database %s, column %s is missing or has different length than column %s\n",
+				resName, job.queryName, job.dbName, column, res.ValueFrom)
+			continue
+		}
+
+		if column, ok := checkColumns(out, res.TagsFrom, len(out[valueFrom])); !ok {
+			// log result whose tags cannot be obtained and take the next one
+			fmt.Fprintf(os.Stderr, "Cannot get tags of result %s of query %s for database %s, column %s is missing or has different length than column %s\n",
+				resName, job.queryName, job.dbName, column, res.ValueFrom)
+			continue
+		}
+
+		timestampFrom := strings.ToLower(res.TimestampFrom)
+		if isNotEmpty(timestampFrom) && len(out[timestampFrom]) != len(out[valueFrom]) {
+			// log result whose timestamps cannot be obtained and take the next one
+			fmt.Fprintf(os.Stderr, "Cannot get timestamps of result %s of query %s for database %s, column %s is missing or has different length than column %s\n",
+				resName, job.queryName, job.dbName, res.TimestampFrom, res.ValueFrom)
+			continue
+		}
+
+		for index, value := range out[valueFrom] {
+			instance, err := createInstance(out, res.InstanceFrom, index)
+			if err != nil {
+				// log row whose instance cannot be obtained and take the next one
+				fmt.Fprintf(os.Stderr, "Cannot get instance of result %s of query %s for database %s: %v\n",
+					resName, job.queryName, job.dbName, err)
+				continue
+			}
+
+			key := createNamespace(job.dbName, resName, res.InstancePrefix, instance)
+
+			if isEmpty(res.ValueType) && len(res.ValueMap) == 0 {
+				// type of value is deduced from type of column only when it is not declared for the result
+				value = executor.ConvertText(value, job.kinds[valueFrom])
+			}
+
+			if value == nil {
+				switch res.NullPolicy {
+				case "skip":
+					if advance {
+						db.NullsSuppressed++
+					}
+					continue
+
+				case "default":
+					value = res.NullDefault
+
+				case "nan":
+					value = math.NaN()
+
+				case "fail":
+					return nil, fmt.Errorf("column %s of metric %s is NULL", res.ValueFrom, key)
+				}
+			}
+
+			if len(res.ValueMap) > 0 && value != nil {
+				mapped, ok := mapValue(value, res.ValueMap, res.Unmapped)
+				if !ok {
+					// log value which is not mapped and take the next one
+					fmt.Fprintf(os.Stderr, "Value `%v` of metric %s does not match any of value_map\n", fixDataType(value), key)
+					continue
+				}
+				value = mapped
+			}
+
+			var tags map[string]string
+			if len(res.TagsFrom) > 0 {
+				tags = map[string]string{}
+				for _, column := range res.TagsFrom {
+					tags[column] = fmt.Sprintf("%v", fixDataType(out[strings.ToLower(column)][index]))
+				}
+			}
+
+			var timestamp time.Time
+			if isNotEmpty(timestampFrom) {
+				var err error
+				if timestamp, err = parseTimestamp(out[timestampFrom][index]); err != nil {
+					// time of collection is used instead
+					fmt.Fprintf(os.Stderr, "Cannot get timestamp of metric %s from column %s: %v\n", key, res.TimestampFrom, err)
+				}
+			}
+
+			metricValue := fixDataType(value)
+			if isNotEmpty(res.ValueType) {
+				converted, err := convertValue(value, res.ValueType)
+				if err != nil {
+					// log value which cannot be converted and take the next one
+					fmt.Fprintf(os.Stderr, "Cannot convert value of metric %s to %s: %v\n", key, res.ValueType, err)
+					continue
+				}
+				metricValue = converted
+			}
+
+			named = append(named, namedSample{name: key, kind: res.Kind, output: res.Output,
+				sample: sample{value: metricValue, tags: tags, timestamp: timestamp}})
+		}
+	}
+
+	return named, nil
+}
+
+// checkColumns checks if all `columns` are present in output of query `out` and have length equal to `length`,
+// otherwise returns false and name of the first column which does not meet these conditions
+func checkColumns(out map[string][]interface{}, columns []string, length int) (string, bool) {
+	for _, column := range columns {
+		if len(out[strings.ToLower(column)]) != length {
+			return column, false
+		}
+	}
+	return "", true
+}
+
+// createInstance returns instance of the row `index` of query output `out`, which consists of values
+// of columns `instanceFrom` (each one preceded by its prefix if defined) separated by slashes; error is returned
+// when any of values is empty or NULL, as each column has to give exactly one element of namespace
+func createInstance(out map[string][]interface{}, instanceFrom []dtype.InstanceColumn, index int) (string, error) {
+	elements := []string{}
+
+	for _, ic := range instanceFrom {
+		raw := out[strings.ToLower(ic.Column)][index]
+		value := fmt.Sprintf("%v", fixDataType(raw))
+		if raw == nil || isEmpty(value) {
+			return "", fmt.Errorf("column %s of row %d is empty", ic.Column, index+1)
+		}
+		if isNotEmpty(ic.Prefix) {
+			elements = append(elements, ic.Prefix)
+		}
+		elements = append(elements, value)
+	}
+
+	return strings.Join(elements, "/"), nil
+}
+
+// suppressesNulls returns true when any of results of queries `qrs` executed for database `db` skips NULL values
+func suppressesNulls(db *dtype.Database, qrs map[string]*dtype.Query) bool {
+	for _, queryName := range db.QrsToExec {
+		query, ok := qrs[queryName]
+		if !ok {
+			continue
+		}
+		for _, res := range query.Results {
+			if res.NullPolicy == "skip" {
+				return true
+			}
+		}
+	}
+	return false
+}
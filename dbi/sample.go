@@ -0,0 +1,87 @@
+// +build linux
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbi
+
+import (
+	"fmt"
+	"time"
+)
+
+// sample is a value of metric obtained from output of query together with its tags and timestamp
+// (zero means time of collection)
+type sample struct {
+	value     interface{}
+	tags      map[string]string
+	timestamp time.Time
+}
+
+// samples holds values of metrics, where keys are metrics names; there are more samples of the same metric
+// only if they are distinguished by tags
+type samples map[string][]sample
+
+// add adds sample `s` of metric `ns`, error is returned when there is already a sample of this metric with the same tags
+func (data samples) add(ns string, s sample) error {
+	for _, existing := range data[ns] {
+		if !equalTags(existing.tags, s.tags) {
+			continue
+		}
+		if len(s.tags) == 0 {
+			return fmt.Errorf("Namespace `%s` has to be unique, but is not", ns)
+		}
+		return fmt.Errorf("Namespace `%s` with tags %v has to be unique, but is not", ns, s.tags)
+	}
+
+	data[ns] = append(data[ns], s)
+	return nil
+}
+
+// equalTags returns true when tags `a` and `b` contain the same keys and values
+func equalTags(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, exist := b[key]; !exist || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeTags returns tags of metric defined in task merged with tags of sample, the latter take precedence
+func mergeTags(taskTags, sampleTags map[string]string) map[string]string {
+	if len(sampleTags) == 0 {
+		return taskTags
+	}
+
+	tags := map[string]string{}
+	for key, value := range taskTags {
+		tags[key] = value
+	}
+	for key, value := range sampleTags {
+		tags[key] = value
+	}
+	return tags
+}
+
+// timestampOf returns timestamp of sample `s`, or current time if it is not defined
+func timestampOf(s sample) time.Time {
+	if s.timestamp.IsZero() {
+		return time.Now()
+	}
+	return s.timestamp
+}
@@ -0,0 +1,29 @@
+// +build linux,!go1.11
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbi
+
+import (
+	"database/sql"
+)
+
+// poolStats returns statistics of connection pool as a map to their values, where keys are names of self-metrics;
+// sql.DBStats of Go older than 1.11 contains only the number of open connections
+func poolStats(stats sql.DBStats) map[string]interface{} {
+	return map[string]interface{}{
+		"open_connections": int64(stats.OpenConnections),
+	}
+}
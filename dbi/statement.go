@@ -0,0 +1,95 @@
+// +build linux
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbi
+
+import (
+	"fmt"
+
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/dtype"
+)
+
+// selectStatement returns statement of query to be executed for database `db`: the first variant defined
+// for its driver whose range of versions includes version of server, otherwise the default statement of query
+func selectStatement(db *dtype.Database, query *dtype.Query) (string, error) {
+	for _, s := range query.Statements[db.Driver] {
+		if s.MinVersion == nil && s.MaxVersion == nil {
+			return s.Statement, nil
+		}
+
+		if err := detectVersion(db); err != nil {
+			return "", err
+		}
+
+		if s.Matches(db.ServerVersion) {
+			return s.Statement, nil
+		}
+	}
+
+	if isEmpty(query.Statement) {
+		if len(query.Statements[db.Driver]) > 0 {
+			return "", fmt.Errorf("Query has no statement for version %s of server", db.ServerVersion)
+		}
+		return "", fmt.Errorf("Query has no statement for SQL Driver %s", db.Driver)
+	}
+
+	return query.Statement, nil
+}
+
+// detectVersion determines version of server of database `db` unless it is already known
+func detectVersion(db *dtype.Database) error {
+	if db.ServerVersion != nil {
+		return nil
+	}
+
+	driver, err := getDriver(db.Driver)
+	if err != nil {
+		return err
+	}
+
+	if isEmpty(driver.Version) {
+		return fmt.Errorf("SQL Driver %s does not support determining version of server", db.Driver)
+	}
+
+	// prepared statements are cached by names of queries, which cannot be empty, so the name does not collide with them
+	out, _, err := executeQuery(db.Executor, "", driver.Version, db.QueryTimeout)
+	if err != nil {
+		return fmt.Errorf("Cannot determine version of server: %v", redactError(err, db))
+	}
+
+	for _, values := range out {
+		if len(values) == 0 {
+			break
+		}
+
+		var str string
+		switch value := values[0].(type) {
+		case []byte:
+			str = string(value)
+		default:
+			str = fmt.Sprint(value)
+		}
+
+		version, err := dtype.ParseVersion(str)
+		if err != nil {
+			return fmt.Errorf("Cannot determine version of server: %v", err)
+		}
+		db.ServerVersion = version
+		return nil
+	}
+
+	return fmt.Errorf("Cannot determine version of server: no version returned")
+}
@@ -0,0 +1,106 @@
+// +build linux
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbi
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unixMillisThreshold distinguishes Unix timestamps given in milliseconds from these given in seconds,
+// it is equal to Unix time in seconds of year 5138
+const unixMillisThreshold = 1e11
+
+// timestampLayouts contains formats of timestamps returned as strings by supported drivers
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// parseTimestamp converts value of column to time; it accepts time.Time, Unix time in seconds or milliseconds
+// (as a number or a numeric string) and strings in formats listed in `timestampLayouts` (UTC is assumed
+// when time zone is not given)
+func parseTimestamp(arg interface{}) (time.Time, error) {
+	switch value := arg.(type) {
+	case time.Time:
+		return value, nil
+
+	case []byte:
+		return parseTimestampString(string(value))
+
+	case string:
+		return parseTimestampString(value)
+
+	case int64:
+		return unixTime(float64(value)), nil
+
+	case int:
+		return unixTime(float64(value)), nil
+
+	case int32:
+		return unixTime(float64(value)), nil
+
+	case uint64:
+		return unixTime(float64(value)), nil
+
+	case float64:
+		return unixTime(value), nil
+
+	case float32:
+		return unixTime(float64(value)), nil
+
+	case nil:
+		return time.Time{}, fmt.Errorf("timestamp is NULL")
+	}
+
+	return time.Time{}, fmt.Errorf("unsupported type of timestamp %T", arg)
+}
+
+// parseTimestampString converts string `str` to time, see parseTimestamp
+func parseTimestampString(str string) (time.Time, error) {
+	str = strings.TrimSpace(str)
+
+	if number, err := strconv.ParseFloat(str, 64); err == nil {
+		return unixTime(number), nil
+	}
+
+	for _, layout := range timestampLayouts {
+		if t, err := time.ParseInLocation(layout, str, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unsupported format of timestamp `%s`", str)
+}
+
+// unixTime returns time of Unix timestamp `number` given in seconds or milliseconds
+func unixTime(number float64) time.Time {
+	if math.Abs(number) >= unixMillisThreshold {
+		number /= 1000
+	}
+
+	sec, frac := math.Modf(number)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
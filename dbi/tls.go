@@ -0,0 +1,126 @@
+// +build linux
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbi
+
+import (
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/dtype"
+)
+
+// tlsConfig returns TLS configuration of connection to the database based on its SSL mode:
+// "require" - encrypt the connection without verification of the server certificate,
+// "verify-ca" - additionally verify that the server certificate is signed by trusted CA,
+// "verify-full" - additionally verify that the server host name matches the one in the certificate
+func tlsConfig(db *dtype.Database) (*tls.Config, error) {
+	config := &tls.Config{ServerName: db.Host}
+
+	if isNotEmpty(db.SSLServerName) {
+		config.ServerName = db.SSLServerName
+	}
+
+	if isNotEmpty(db.SSLCA) {
+		pem, err := ioutil.ReadFile(db.SSLCA)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("Cannot parse CA certificates from file `%s`", db.SSLCA)
+		}
+	}
+
+	if isNotEmpty(db.SSLCert) {
+		cert, err := tls.LoadX509KeyPair(db.SSLCert, db.SSLKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	switch db.SSLMode {
+	case "require":
+		config.InsecureSkipVerify = true
+
+	case "verify-ca":
+		// skip the default verification which checks host name as well, verify certificate chain only
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = verifyCertificateChain(config.RootCAs)
+
+	case "verify-full":
+		// default verification of certificate chain and host name
+
+	default:
+		return nil, fmt.Errorf("SSL mode %s is not supported", db.SSLMode)
+	}
+
+	return config, nil
+}
+
+// verifyCertificateChain returns function which verifies the server certificate against trusted CAs `roots`
+// (system roots if nil), without checking the host name
+func verifyCertificateChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("Server did not provide any certificate")
+		}
+
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs[i] = cert
+		}
+
+		opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+
+		_, err := certs[0].Verify(opts)
+		return err
+	}
+}
+
+// registerMySQLTLS registers TLS configuration of the database in MySQL driver and returns its name
+// which is used as the value of `tls` parameter in data source name
+func registerMySQLTLS(db *dtype.Database) (string, error) {
+	config, err := tlsConfig(db)
+	if err != nil {
+		return "", err
+	}
+
+	// name is derived from TLS settings, so the same settings are registered under the same name
+	hash := sha1.Sum([]byte(fmt.Sprintf("%s|%s|%s|%s|%s|%s", db.Host, db.SSLMode, db.SSLCA, db.SSLCert, db.SSLKey, db.SSLServerName)))
+	name := fmt.Sprintf("dbi-%x", hash[:8])
+
+	if err := mysql.RegisterTLSConfig(name, config); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
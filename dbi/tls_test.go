@@ -0,0 +1,320 @@
+// +build linux,small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+Copyright 2016 Intel Corporation
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dbi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/dtype"
+	"github.com/intelsdi-x/snap-plugin-collector-dbi/dbi/parser"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testPKI holds paths to files of self-signed CA and certificate of server `localhost` signed by it
+type testPKI struct {
+	dir        string
+	caFile     string
+	certFile   string
+	keyFile    string
+	serverCert tls.Certificate
+}
+
+// newTestPKI generates self-signed CA and server certificate and saves them in temporary directory
+func newTestPKI() (*testPKI, error) {
+	dir, err := ioutil.TempDir("", "dbi-tls")
+	if err != nil {
+		return nil, err
+	}
+	pki := &testPKI{
+		dir:      dir,
+		caFile:   filepath.Join(dir, "ca.pem"),
+		certFile: filepath.Join(dir, "cert.pem"),
+		keyFile:  filepath.Join(dir, "key.pem"),
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dbi test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	for file, data := range map[string][]byte{pki.caFile: caPEM, pki.certFile: certPEM, pki.keyFile: keyPEM} {
+		if err := ioutil.WriteFile(file, data, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	pki.serverCert, err = tls.X509KeyPair(certPEM, keyPEM)
+	return pki, err
+}
+
+// serveTLS starts in-process TLS listener which completes handshakes and returns its address
+func (pki *testPKI) serveTLS() (net.Listener, error) {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{pki.serverCert}})
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+
+	return ln, nil
+}
+
+// dialTLS performs TLS handshake with server listening on `addr` using TLS configuration of database `db`
+func dialTLS(addr string, db *dtype.Database) error {
+	config, err := tlsConfig(db)
+	if err != nil {
+		return err
+	}
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestTLSConfig(t *testing.T) {
+	pki, err := newTestPKI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(pki.dir)
+
+	ln, err := pki.serveTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	addr := ln.Addr().String()
+
+	Convey("connecting to TLS server", t, func() {
+
+		Convey("when SSL mode is not supported", func() {
+			err := dialTLS(addr, &dtype.Database{Host: "localhost", SSLMode: "prefer"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("when SSL mode is require", func() {
+			err := dialTLS(addr, &dtype.Database{Host: "127.0.0.1", SSLMode: "require"})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("when SSL mode is verify-ca", func() {
+			Convey("and server certificate is signed by trusted CA", func() {
+				err := dialTLS(addr, &dtype.Database{Host: "127.0.0.1", SSLMode: "verify-ca", SSLCA: pki.caFile})
+				So(err, ShouldBeNil)
+			})
+
+			Convey("and server certificate is not signed by trusted CA", func() {
+				err := dialTLS(addr, &dtype.Database{Host: "127.0.0.1", SSLMode: "verify-ca"})
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("when SSL mode is verify-full", func() {
+			Convey("and server name matches the certificate", func() {
+				err := dialTLS(addr, &dtype.Database{Host: "127.0.0.1", SSLMode: "verify-full", SSLCA: pki.caFile, SSLServerName: "localhost"})
+				So(err, ShouldBeNil)
+			})
+
+			Convey("and server name does not match the certificate", func() {
+				err := dialTLS(addr, &dtype.Database{Host: "127.0.0.1", SSLMode: "verify-full", SSLCA: pki.caFile})
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("when client certificate is defined", func() {
+			db := &dtype.Database{Host: "localhost", SSLMode: "verify-full", SSLCA: pki.caFile, SSLCert: pki.certFile, SSLKey: pki.keyFile}
+			config, err := tlsConfig(db)
+			So(err, ShouldBeNil)
+			So(config.Certificates, ShouldHaveLength, 1)
+			So(dialTLS(addr, db), ShouldBeNil)
+		})
+
+		Convey("when CA file does not contain certificates", func() {
+			err := dialTLS(addr, &dtype.Database{Host: "localhost", SSLMode: "verify-full", SSLCA: pki.keyFile})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("building data source name with SSL options", t, func() {
+
+		Convey("for MySQL", func() {
+			dsn, err := mysqlDSN(&dtype.Database{Host: "localhost", Port: "3306", Username: "u", Password: "p", DBName: "db"})
+			So(err, ShouldBeNil)
+			So(dsn, ShouldEqual, "u:p@tcp(localhost:3306)/db")
+
+			dsn, err = mysqlDSN(&dtype.Database{Host: "localhost", Port: "3306", Username: "u", Password: "p", DBName: "db",
+				SSLMode: "verify-full", SSLCA: pki.caFile})
+			So(err, ShouldBeNil)
+			So(dsn, ShouldStartWith, "u:p@tcp(localhost:3306)/db?tls=dbi-")
+		})
+
+		Convey("for PostgreSQL", func() {
+			dsn, err := postgresDSN(&dtype.Database{Host: "localhost", Port: "5432", Username: "u", Password: "p", DBName: "db"})
+			So(err, ShouldBeNil)
+			So(dsn, ShouldEqual, "postgres://u:p@localhost:5432/db?sslmode=disable")
+
+			dsn, err = postgresDSN(&dtype.Database{Host: "localhost", Port: "5432", Username: "u", Password: "p", DBName: "db",
+				SSLMode: "verify-full", SSLCA: "/ca.pem", SSLCert: "/cert.pem", SSLKey: "/key.pem"})
+			So(err, ShouldBeNil)
+			So(dsn, ShouldEqual, "postgres://u:p@localhost:5432/db?sslcert=%2Fcert.pem&sslkey=%2Fkey.pem&sslmode=verify-full&sslrootcert=%2Fca.pem")
+
+			_, err = postgresDSN(&dtype.Database{Host: "localhost", SSLMode: "verify-full", SSLServerName: "db.example.com"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("validating SSL options of setfile", t, func() {
+		setfile := filepath.Join(pki.dir, "setfile.json")
+
+		parseDriver := func(driver, driverOption string) error {
+			content := fmt.Sprintf(`{"queries": [{"name": "q1", "statement": "select 1"}],
+				"databases": [{"name": "db1", "driver": "%s", "driver_option": %s, "dbqueries": [{"query": "q1"}]}]}`, driver, driverOption)
+			if err := ioutil.WriteFile(setfile, []byte(content), 0600); err != nil {
+				return err
+			}
+			_, _, err := parser.GetDBItemsFromConfig(setfile)
+			return err
+		}
+		parse := func(driverOption string) error {
+			return parseDriver("mysql", driverOption)
+		}
+
+		Convey("when SSL options are valid", func() {
+			err := parse(fmt.Sprintf(`{"ssl_mode": "verify-full", "ssl_ca": "%s", "ssl_cert": "%s", "ssl_key": "%s"}`,
+				pki.caFile, pki.certFile, pki.keyFile))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("when SSL mode is not supported", func() {
+			err := parse(`{"ssl_mode": "prefer"}`)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("when CA file does not exist", func() {
+			err := parse(`{"ssl_mode": "verify-full", "ssl_ca": "/nonexistent/ca.pem"}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "ssl_ca")
+		})
+
+		Convey("when CA file is a directory", func() {
+			err := parse(fmt.Sprintf(`{"ssl_mode": "verify-full", "ssl_ca": "%s"}`, pki.dir))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("when client key is missing", func() {
+			err := parse(fmt.Sprintf(`{"ssl_mode": "verify-full", "ssl_cert": "%s"}`, pki.certFile))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("when SSL options would be ignored because SSL is disabled", func() {
+			err := parse(fmt.Sprintf(`{"ssl_ca": "%s"}`, pki.caFile))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "SSL is disabled")
+
+			err = parseDriver("postgres", fmt.Sprintf(`{"ssl_mode": "disable", "ssl_cert": "%s", "ssl_key": "%s"}`, pki.certFile, pki.keyFile))
+			So(err, ShouldNotBeNil)
+
+			err = parse(`{"ssl_server_name": "db.example.com"}`)
+			So(err, ShouldNotBeNil)
+
+			So(parse(`{"ssl_mode": "disable"}`), ShouldBeNil)
+		})
+
+		Convey("when SSL options are not supported by driver", func() {
+			for _, driver := range []string{"sqlite3", "mssql", "sqlserver"} {
+				err := parseDriver(driver, fmt.Sprintf(`{"ssl_mode": "verify-full", "ssl_ca": "%s"}`, pki.caFile))
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, driver)
+
+				So(parseDriver(driver, `{"ssl_mode": "require"}`), ShouldNotBeNil)
+			}
+
+			err := parseDriver("postgres", `{"ssl_mode": "verify-full", "ssl_server_name": "db.example.com"}`)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}